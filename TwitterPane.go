@@ -1,32 +1,65 @@
 package main
 
-// TODO - scrolling text for tweet name / messages?
-
 import (
 	"image"
 	"image/color"
 	"image/draw"
+	"sync"
 	"time"
 
 	"fmt"
 
 	"github.com/ninjasphere/gestic-tools/go-gestic-sdk"
-	"github.com/ninjasphere/sphere-go-led-controller/fonts/O4b03b"
 	"github.com/ninjasphere/sphere-go-led-controller/util"
 )
 
 var tapInterval = time.Millisecond * 450
-var updateFrequency = time.Second * 2
 
-// app states
+// tickInterval is how often the pane checks the status and its state's timeouts
+var tickInterval = time.Millisecond * 100
+
+// defaultConfirmTime and defaultUndoTime are the seconds to confirm sending and to undo it when they're turned on
+const defaultConfirmTime = 5
+const defaultUndoTime = 10
+
+// flashInterval is how fast the tweet number flashes while waiting for confirmation
+var flashInterval = time.Millisecond * 250
+
+// pendingColour is for showing messages that are queued to be retried
+var pendingColour = color.RGBA{255, 140, 0, 255}
+
+// unreadColour is for showing unread mentions and direct messages
+var unreadColour = color.RGBA{255, 0, 0, 255}
+
+// PaneState is what the pane is showing and how it reacts to gestures (see paneStates for what each one does)
+type PaneState int
+
+// pane states
 const (
-	ErrorAccount = iota
+	ErrorAccount PaneState = iota
 	Choosing
 	Tweeting
 	TweetFailed
 	TweetSucceeded
+	TweetPending
+	RateLimited
+	Previewing
+	Inbox
+	Confirming
+	Undoing
+	Deleted
 )
 
+var stateNames = []string{"ErrorAccount", "Choosing", "Tweeting", "TweetFailed", "TweetSucceeded", "TweetPending",
+	"RateLimited", "Previewing", "Inbox", "Confirming", "Undoing", "Deleted"}
+
+func (s PaneState) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("PaneState(%d)", s)
+	}
+	return stateNames[s]
+}
+
 // state images
 var images map[string]util.Image
 
@@ -41,19 +74,37 @@ func init() {
 }
 
 // LEDPane stores the data we want to access
+// Render and Gesture are called by the LED controller while the timers and sending run on their own goroutines,
+// so everything is guarded by the mutex. It's held by the exported methods and timer callbacks (the unexported
+// methods expect it to be held) and released while sending so the pane keeps rendering.
+// lastFrame is the last image rendered before sending, for snapshots (which would otherwise show the sending animation)
 type LEDPane struct {
-	lastTap              time.Time
-	lastDoubleTap        time.Time
-	lastTapLocation      gestic.Location
-	changeTweetDirection int
-	currentImage         util.Image
-	app                  *TwitterApp
-	state                int
-	hasStoredTweets      bool
-	numberOfTweets       int
-	currentTweetNumber   int
-	updateTimer          *time.Timer
-	tapTimer             *time.Timer
+	sync.Mutex
+	lastTap            time.Time
+	lastDoubleTap      time.Time
+	lastTapLocation    gestic.Location
+	tapAction          string
+	currentImage       util.Image
+	app                *TwitterApp
+	state              PaneState
+	entered            time.Time
+	group              string
+	hasStoredTweets    bool
+	numberOfTweets     int
+	currentTweetNumber int
+	updateTimer        *time.Timer
+	tapTimer           *time.Timer
+	confirmUntil       time.Time
+	sent               *SendResult
+	undoUntil          time.Time
+	scroller           *TextScroller
+	notification       Notification
+	airWheelActive     bool
+	airWheelCounter    int
+	hoverStart         time.Time
+	hoverPosition      gestic.Position
+	hovered            bool
+	lastFrame          *image.RGBA
 }
 
 // NewLEDPane creates an LEDPane with the data and timers initialised
@@ -63,17 +114,27 @@ func NewLEDPane(a *TwitterApp) *LEDPane {
 		lastTap:         time.Now(),
 		lastDoubleTap:   time.Now(),
 		app:             a,
+		state:           ErrorAccount,
+		entered:         time.Now(),
 		hasStoredTweets: false,
 		numberOfTweets:  1, // to avoid divide by zero error the first time it's run
 	}
 
+	// the timer callbacks wait for the lock, so they can't run before the timers are set
+	p.Lock()
+	defer p.Unlock()
 	p.updateTimer = time.AfterFunc(0, p.UpdateStatus)
-	p.tapTimer = time.AfterFunc(0, p.TapAction)
+	p.tapTimer = time.AfterFunc(tapInterval, p.TapAction)
+	p.tapTimer.Stop()
 	return p
 }
 
 // Gesture is called by the system when the LED matrix receives any kind of gesture
+// It works out which action the gesture means and passes it to the current state
 func (p *LEDPane) Gesture(gesture *gestic.GestureMessage) {
+	p.Lock()
+	defer p.Unlock()
+
 	//	log.Infof("gesture received - %v, %v", gesture.Touch, gesture.Position)
 	//	log.Infof("Touch %v, Tap %v, Since: %v, Double %v, Since %v", gesture.Touch.Active(), gesture.Tap.Active(), time.Since(p.lastTap), gesture.DoubleTap.Active(), time.Since(p.lastDoubleTap))
 
@@ -85,33 +146,29 @@ func (p *LEDPane) Gesture(gesture *gestic.GestureMessage) {
 		p.lastTap = time.Now()
 		log.Infof("Tap! %v", lastLocation)
 
-		// do tap action only if we are in the right state
-		if p.state == Choosing && p.hasStoredTweets {
-			// start timer that will be stopped if double tap happens in time
-			// this avoids the problem of the first tap of a double being actioned as a tap
-			p.tapTimer.Reset(tapInterval)
-			// change between images - right or left
-			if lastLocation.West && !lastLocation.East {
-				p.changeTweetDirection = -1
-			} else {
-				p.changeTweetDirection = 1
-			}
+		// what the tap does if it isn't the first of a double tap
+		// (tapping the top switches between tweets and unread mentions/messages, the sides change between them)
+		if lastLocation.North && !lastLocation.South {
+			p.tapAction = ActionInbox
+		} else if lastLocation.West && !lastLocation.East {
+			p.tapAction = ActionPrevious
+		} else {
+			p.tapAction = ActionNext
 		}
+		p.handle(ActionTap)
 	}
 
 	if gesture.DoubleTap.Active() && time.Since(p.lastDoubleTap) > tapInterval {
 		p.lastDoubleTap = time.Now()
 		log.Infof("Double Tap!")
-		if p.state == Choosing {
-			// don't do tap action since we're double tapping
-			p.tapTimer.Stop()
-			// TODO - learn why I need "go" here or the LED connection gets lost
-			// ("WARNING matrix RemoteMatrix.go:70 Lost connection to led controller: EOF")
-			//		go p.app.PostDirectMessage("Nice one? I hope so!", "@lindsaymarkward")
-
-			go p.tweetIt()
-		}
+		// don't do tap action since we're double tapping
+		p.tapTimer.Stop()
+		p.handle(ActionDoubleTap)
+		return
 	}
+
+	// airwheel, flicks and hovering
+	p.moreGestures(gesture)
 }
 
 // KeepAwake sets whether the display fades after 30 seconds (false) or stays on (true)
@@ -129,110 +186,272 @@ func (p *LEDPane) IsEnabled() bool {
 func (p *LEDPane) Render() (*image.RGBA, error) {
 	//	log.Infof("State: %v", p.state)
 
+	p.Lock()
+	defer p.Unlock()
+
 	// create an empty 16*16 RGBA image for the Draw function to draw into (to be returned)
 	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
 
-	switch p.state {
-	case Tweeting:
-		draw.Draw(img, img.Bounds(), images["animated"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{20, 154, 233, 255})
-	case Choosing:
-		// different tweet numbers and DM or TWT text
-		draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		if !p.hasStoredTweets {
-			O4b03b.Font.DrawString(img, 4, 5, "NO", color.RGBA{255, 0, 0, 255})
-			//			drawText("NO", color.RGBA{255, 250, 0, 255}, 2, img)
-		} else {
-			// display tweet number and type on Spheramid
-			//			drawText(fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 250, 0, 255}, 2, img)
-			O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 250, 0, 255})
-			if p.app.config.Tweets[p.app.config.TweetNames[p.currentTweetNumber]].To == "" {
-				O4b03b.Font.DrawString(img, 2, 10, "TWT", color.RGBA{20, 255, 20, 255})
-			} else {
-				O4b03b.Font.DrawString(img, 3, 10, "DM", color.RGBA{20, 255, 250, 255})
-			}
-		}
-	case ErrorAccount:
-		// @ with animated cross through it
-		draw.Draw(img, img.Bounds(), images["at"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		draw.Draw(img, img.Bounds(), images["error"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-	case TweetSucceeded:
-		// bird with animated tick and tweet number
-		draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		draw.Draw(img, img.Bounds(), images["tick"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 255, 255, 255})
-	case TweetFailed:
-		// bird with animated cross through it and tweet number
-		draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		draw.Draw(img, img.Bounds(), images["error"].GetNextFrame(), image.Point{0, 0}, draw.Over)
-		O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 255, 255, 255})
+	paneStates[p.state].Render(p, img)
+
+	// orange corner shows that there are messages waiting to be retried
+	if p.state != TweetPending && p.state != RateLimited && p.app.PendingCount() > 0 {
+		draw.Draw(img, image.Rect(14, 0, 16, 2), &image.Uniform{pendingColour}, image.Point{0, 0}, draw.Src)
+	}
+	if p.state != Tweeting {
+		p.lastFrame = img
 	}
 	// return the image we've created to be rendered to the matrix
 	return img, nil
 }
 
-// UpdateStatus (regularly) checks the account (API) initialisation status and number of tweets stored
-// and sets the pane state accordingly.
+// SnapshotFrame returns the image for a snapshot of the LED matrix, which is what was showing before sending
+// if the pane is sending (so the snapshot isn't of the sending animation)
+func (p *LEDPane) SnapshotFrame() (*image.RGBA, error) {
+	p.Lock()
+	frame := p.lastFrame
+	sending := p.state == Tweeting
+	p.Unlock()
+	if sending && frame != nil {
+		return frame, nil
+	}
+	return p.Render()
+}
+
+// UpdateStatus (regularly) checks the number of tweets stored and lets the current state check
+// the account (API) initialisation status and its timeouts.
 // This gets updated regularly so you don't have to restart the app when you update the config
 func (p *LEDPane) UpdateStatus() {
-	if !p.app.Initialised {
-		p.state = ErrorAccount
-	} else {
-		p.state = Choosing
-		p.numberOfTweets = len(p.app.config.Tweets)
-		if p.numberOfTweets == 0 {
-			p.currentTweetNumber = -1
-			p.hasStoredTweets = false
-		} else if p.hasStoredTweets == false {
-			// this is the first update where there are now stored tweets
-			p.currentTweetNumber = 0
-			p.hasStoredTweets = true
+	p.Lock()
+	defer p.Unlock()
+
+	if groups := p.app.NavigationGroups(); indexOf(groups, p.group) < 0 {
+		// the group has been deleted or has no tweets left, so start again in the first one
+		p.group = groups[0]
+		p.hasStoredTweets = false
+	}
+	p.numberOfTweets = len(p.app.GroupTweetNames(p.group))
+	if p.numberOfTweets == 0 {
+		p.currentTweetNumber = -1
+		p.hasStoredTweets = false
+	} else if p.hasStoredTweets == false {
+		// this is the first update where there are now stored tweets
+		p.currentTweetNumber = 0
+		p.hasStoredTweets = true
+		if p.state == Choosing {
+			p.scrollName()
+		}
+	} else if p.currentTweetNumber >= p.numberOfTweets {
+		// tweets have been deleted
+		p.currentTweetNumber = p.numberOfTweets - 1
+		if p.state == Choosing {
+			p.scrollName()
 		}
 	}
+
+	paneStates[p.state].Tick(p)
 	//	log.Infof("update. State is %v", p.state)
-	p.updateTimer.Reset(updateFrequency)
+	p.updateTimer.Reset(tickInterval)
 }
 
-// TapAction changes to the next/previous stored tweet (run on a timer when tapped)
+// TapAction does what a tap means in the current state (run on a timer when tapped, so it doesn't happen for
+// the first tap of a double tap)
 func (p *LEDPane) TapAction() {
-	p.currentTweetNumber += p.changeTweetDirection
+	p.Lock()
+	defer p.Unlock()
+	p.handle(p.tapAction)
+}
+
+// startTapTimer starts the timer that will be stopped if a double tap happens in time
+// this avoids the problem of the first tap of a double being actioned as a tap
+func (p *LEDPane) startTapTimer() {
+	p.tapTimer.Reset(tapInterval)
+}
+
+// handle passes action to the current state
+func (p *LEDPane) handle(action string) {
+	paneStates[p.state].HandleGesture(p, action)
+}
+
+// setState changes to the state next (if the transition table allows it), exiting the current state and entering next
+func (p *LEDPane) setState(next PaneState) {
+	if next == p.state {
+		return
+	}
+	if !canChange(p.state, next) {
+		log.Errorf("The pane can't change from %v to %v", p.state, next)
+		return
+	}
+	paneStates[p.state].Exit(p)
+	p.state = next
+	p.entered = time.Now()
+	paneStates[next].Enter(p)
+}
+
+// changeTweet moves to the stored tweet that is direction places from the current one
+func (p *LEDPane) changeTweet(direction int) {
+	if !p.hasStoredTweets {
+		return
+	}
+
+	p.currentTweetNumber += direction
 	p.currentTweetNumber %= p.numberOfTweets
 	if p.currentTweetNumber < 0 {
 		p.currentTweetNumber = p.numberOfTweets - 1
 	}
+	p.scrollName()
 }
 
-// tweetIt calls app's appropriate function to post tweet or direct message
-// should handle result and set state
-func (p *LEDPane) tweetIt() {
-	var err error
-	// stop the regular status updating while we tweet and handle success/failure
-	p.updateTimer.Stop()
-	p.state = Tweeting
-
-	tweet := p.app.config.Tweets[p.app.config.TweetNames[p.currentTweetNumber]]
-	tweet.Number += 1
-	// update config to update this number (to avoid Twitter rejecting duplicate tweets/messages)
-	p.app.config.Tweets[p.app.config.TweetNames[p.currentTweetNumber]] = tweet
-	p.app.SendEvent("config", p.app.config)
-
-	log.Infof("Tweeting: %v to %v (%v)", tweet.Message, tweet.To, tweet.Number)
-
-	message := fmt.Sprintf("%s %d", tweet.Message, tweet.Number)
-	if tweet.To == "" {
-		// post tweet
-		err = p.app.PostTweet(message)
+// changeGroup moves to the first tweet in the group that is direction places from the current one
+func (p *LEDPane) changeGroup(direction int) {
+	groups := p.app.NavigationGroups()
+	if !p.hasStoredTweets || len(groups) < 2 {
+		return
+	}
+
+	index := (indexOf(groups, p.group) + direction + len(groups)) % len(groups)
+	p.group = groups[index]
+	p.numberOfTweets = len(p.app.GroupTweetNames(p.group))
+	p.currentTweetNumber = 0
+	p.scrollGroup()
+}
+
+// currentName returns the name of the current stored tweet (blank if there isn't one)
+func (p *LEDPane) currentName() string {
+	names := p.app.GroupTweetNames(p.group)
+	if p.currentTweetNumber < 0 || p.currentTweetNumber >= len(names) {
+		return ""
+	}
+	return names[p.currentTweetNumber]
+}
+
+// scrollName starts scrolling the current tweet's name (if that's turned on)
+func (p *LEDPane) scrollName() {
+	name := p.currentName()
+	if !p.app.displaySettings().ScrollNames || name == "" {
+		p.scroller = nil
+		return
+	}
+	speed, colour := p.app.scrollSettings()
+	p.scroller = NewTextScroller(name, colour, speed, 10)
+}
+
+// scrollGroup starts scrolling the current group's name (if scrolling names is turned on)
+func (p *LEDPane) scrollGroup() {
+	if !p.app.displaySettings().ScrollNames {
+		p.scroller = nil
+		return
+	}
+	speed, colour := p.app.scrollSettings()
+	p.scroller = NewTextScroller(groupTitle(p.group), colour, speed, 10)
+}
+
+// showNotification starts scrolling the unread mention/message that is change places from the current one
+// (or goes back to choosing tweets if there are none left)
+func (p *LEDPane) showNotification(change int) {
+	unread := p.app.Unread()
+	if len(unread) == 0 {
+		p.setState(Choosing)
+		return
+	}
+	index := 0
+	for i, notification := range unread {
+		if notification.ID == p.notification.ID {
+			index = i
+		}
+	}
+	index = (index + change + len(unread)) % len(unread)
+	p.notification = unread[index]
+	speed, colour := p.app.scrollSettings()
+	p.scroller = NewTextScroller(p.notification.Author+": "+p.notification.Text, colour, speed, 10)
+}
+
+// send sends the current tweet, waiting for a tap to confirm it and scrolling it first if those are turned on
+func (p *LEDPane) send() {
+	if !p.hasStoredTweets {
+		return
+	}
+	if p.app.confirmTime() > 0 {
+		p.setState(Confirming)
+		return
+	}
+	p.sendNow()
+}
+
+// sendNow sends the current tweet, scrolling it first if that's turned on (a tap while it's scrolling cancels it)
+func (p *LEDPane) sendNow() {
+	if p.app.displaySettings().ScrollMessages {
+		p.setState(Previewing)
 	} else {
-		// send direct message
-		err = p.app.PostDirectMessage(message, tweet.To)
+		// TODO - learn why I need "go" here or the LED connection gets lost
+		// ("WARNING matrix RemoteMatrix.go:70 Lost connection to led controller: EOF")
+		go p.tweetIt(p.startTweeting())
+	}
+}
+
+// showMessage scrolls the current tweet's message in place of its type, without sending it
+func (p *LEDPane) showMessage() {
+	if !p.hasStoredTweets {
+		return
 	}
-	// handle error - success/fail display
+	message, err := p.app.PreviewMessage(p.currentName())
+	if err != nil {
+		message = err.Error()
+	}
+	speed, colour := p.app.scrollSettings()
+	p.scroller = NewTextScroller(message, colour, speed, 10)
+}
+
+// startTweeting shows the tweeting animation and returns the name of the tweet to send with tweetIt
+func (p *LEDPane) startTweeting() string {
+	p.setState(Tweeting)
+	return p.currentName()
+}
+
+// tweetIt calls app's appropriate function to post tweet or direct message
+// and sets the state from the result. It's run without the lock held so the pane keeps animating while it sends.
+func (p *LEDPane) tweetIt(name string) {
+	// the app updates the tweet's count and variation (to avoid Twitter rejecting duplicate tweets/messages) and sends it
+	result, err := p.app.SendStoredTweet(name, nil)
+
+	p.Lock()
+	defer p.Unlock()
+
+	// handle error - success/fail/queued display (the states go back to choosing after showing it)
 	if err != nil {
 		//		log.Errorf(fmt.Sprintf("Tweetit error: %v", err))
-		p.state = TweetFailed
+		if IsRateLimit(err) {
+			p.setState(RateLimited)
+		} else if result != nil && result.Queued {
+			p.setState(TweetPending)
+		} else {
+			p.setState(TweetFailed)
+		}
+	} else if p.app.undoTime() > 0 && result.ID != "" {
+		// a tap before the undo time runs out deletes it
+		p.sent = result
+		p.setState(Undoing)
 	} else {
-		p.state = TweetSucceeded
+		p.setState(TweetSucceeded)
 	}
-	// reset usual timer which will set state (so it displays success/fail for 2 seconds)
-	p.updateTimer.Reset(updateFrequency)
+}
+
+// undo deletes the tweet that was just sent
+func (p *LEDPane) undo() {
+	if p.sent == nil {
+		return
+	}
+	sent := p.sent
+	p.sent = nil
+	p.setState(Tweeting)
+	go func() {
+		err := p.app.DeleteTweet(sent.Account, sent.ID)
+		p.Lock()
+		defer p.Unlock()
+		if err != nil {
+			p.setState(TweetFailed)
+		} else {
+			p.setState(Deleted)
+		}
+	}()
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestPane makes the pane for a, with its status updated once
+func newTestPane(a *TwitterApp) *LEDPane {
+	p := NewLEDPane(a)
+	a.pane = p
+	p.UpdateStatus()
+	return p
+}
+
+// paneAction does what action means in the pane's current state, like a gesture does
+func paneAction(p *LEDPane, action string) {
+	p.Lock()
+	defer p.Unlock()
+	p.handle(action)
+}
+
+// currentState returns the pane's state
+func currentState(p *LEDPane) PaneState {
+	p.Lock()
+	defer p.Unlock()
+	return p.state
+}
+
+// expireState makes the time for the current state run out, then updates the status
+func expireState(p *LEDPane) {
+	p.Lock()
+	p.entered = p.entered.Add(-time.Hour)
+	p.confirmUntil = p.confirmUntil.Add(-time.Hour)
+	p.undoUntil = p.undoUntil.Add(-time.Hour)
+	p.Unlock()
+	p.UpdateStatus()
+}
+
+// waitForState waits for sending or deleting (which happen without the lock) to change the pane to want
+func waitForState(t *testing.T, p *LEDPane, want PaneState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for state := currentState(p); state != want; state = currentState(p) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pane is %v, want %v", state, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPaneSends(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	p := newTestPane(a)
+	if state := currentState(p); state != Choosing {
+		t.Fatalf("pane is %v with a working account, want Choosing", state)
+	}
+
+	paneAction(p, ActionDoubleTap)
+	waitForState(t, p, TweetSucceeded)
+	if len(server.tweeted("@me")) != 1 {
+		t.Errorf("tweets are %q, want the one sent", server.tweeted("@me"))
+	}
+
+	p.UpdateStatus()
+	if state := currentState(p); state != TweetSucceeded {
+		t.Errorf("pane is %v before the result time, want TweetSucceeded", state)
+	}
+	expireState(p)
+	if state := currentState(p); state != Choosing {
+		t.Errorf("pane is %v after the result time, want Choosing", state)
+	}
+}
+
+func TestPaneAccountErrors(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	p := newTestPane(a)
+
+	a.removeClient("@me")
+	p.UpdateStatus()
+	if state := currentState(p); state != ErrorAccount {
+		t.Fatalf("pane is %v without a working account, want ErrorAccount", state)
+	}
+	paneAction(p, ActionDoubleTap)
+	if state := currentState(p); state != ErrorAccount || len(server.tweeted("@me")) != 0 {
+		t.Errorf("double tapping without a working account changed the pane to %v", state)
+	}
+
+	account, _ := a.Account("@me")
+	a.InitTwitterAPI(account)
+	p.UpdateStatus()
+	if state := currentState(p); state != Choosing {
+		t.Errorf("pane is %v after the account works again, want Choosing", state)
+	}
+}
+
+func TestPaneConfirm(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	a.config.Display.ConfirmSend = true
+	p := newTestPane(a)
+
+	paneAction(p, ActionDoubleTap)
+	if state := currentState(p); state != Confirming {
+		t.Fatalf("pane is %v after double tapping, want Confirming", state)
+	}
+	expireState(p)
+	if state := currentState(p); state != Choosing {
+		t.Errorf("pane is %v when it wasn't confirmed in time, want Choosing", state)
+	}
+
+	paneAction(p, ActionDoubleTap)
+	paneAction(p, ActionCancel)
+	if state := currentState(p); state != Choosing {
+		t.Errorf("pane is %v after cancelling, want Choosing", state)
+	}
+	if len(server.tweeted("@me")) != 0 {
+		t.Fatalf("tweet was sent without being confirmed")
+	}
+
+	paneAction(p, ActionDoubleTap)
+	paneAction(p, ActionTap)
+	waitForState(t, p, TweetSucceeded)
+	if len(server.tweeted("@me")) != 1 {
+		t.Errorf("tweets are %q after confirming, want the one sent", server.tweeted("@me"))
+	}
+}
+
+func TestPaneUndo(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	a.config.Display.UndoSend = true
+	p := newTestPane(a)
+
+	paneAction(p, ActionDoubleTap)
+	waitForState(t, p, Undoing)
+	paneAction(p, ActionTap)
+	waitForState(t, p, Deleted)
+	if tweets := server.tweeted("@me"); len(tweets) != 0 {
+		t.Errorf("tweets are %q after undoing, want none", tweets)
+	}
+	expireState(p)
+
+	paneAction(p, ActionDoubleTap)
+	waitForState(t, p, Undoing)
+	expireState(p)
+	if state := currentState(p); state != Choosing {
+		t.Errorf("pane is %v after the undo time, want Choosing", state)
+	}
+	if tweets := server.tweeted("@me"); len(tweets) != 1 {
+		t.Errorf("tweets are %q when it wasn't undone, want the one sent", tweets)
+	}
+}
+
+func TestPaneFailures(t *testing.T) {
+	reset := http.Header{"X-Rate-Limit-Reset": {strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)}}
+	for _, test := range []struct {
+		status int
+		code   int
+		header http.Header
+		want   PaneState
+		queued int
+	}{
+		{http.StatusForbidden, 64, nil, TweetFailed, 0},
+		{http.StatusServiceUnavailable, 130, nil, TweetPending, 1},
+		{http.StatusTooManyRequests, 88, reset, RateLimited, 1},
+	} {
+		server := newTwitterServer(t)
+		a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+		p := newTestPane(a)
+		server.fail(EndpointTweet, test.status, test.code, test.header)
+
+		paneAction(p, ActionDoubleTap)
+		waitForState(t, p, test.want)
+		if a.PendingCount() != test.queued {
+			t.Errorf("%d messages were queued after a %d error, want %d", a.PendingCount(), test.status, test.queued)
+		}
+		expireState(p)
+		if state := currentState(p); state != Choosing {
+			t.Errorf("pane is %v after showing %v, want Choosing", state, test.want)
+		}
+	}
+}
+
+func TestPaneTransitions(t *testing.T) {
+	for state := range stateNames {
+		if _, ok := paneStates[PaneState(state)]; !ok {
+			t.Errorf("%v has no implementation", PaneState(state))
+		}
+		if len(paneTransitions[PaneState(state)]) == 0 {
+			t.Errorf("%v can't change to anything", PaneState(state))
+		}
+	}
+	if canChange(Tweeting, Choosing) || canChange(ErrorAccount, Tweeting) {
+		t.Errorf("sending can't finish without a result and can't start without a working account")
+	}
+
+	server := newTwitterServer(t)
+	p := newTestPane(newTestApp(t, server))
+	p.Lock()
+	p.setState(TweetSucceeded)
+	state := p.state
+	p.Unlock()
+	if state != Choosing {
+		t.Errorf("pane changed from Choosing to %v, which isn't allowed", state)
+	}
+}
+
+func TestPaneAirWheel(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "one", Message: "1"}, TweetDetails{Name: "two", Message: "2"},
+		TweetDetails{Name: "three", Message: "3"})
+	p := newTestPane(a)
+	p.Lock()
+	defer p.Unlock()
+
+	// turning forwards many times around, the counter wraps at 256
+	counter := 240
+	p.airWheel(counter)
+	for i := 0; i < 20; i++ {
+		counter = (counter + airWheelStep) % 256
+		p.airWheel(counter)
+	}
+	if p.currentTweetNumber != 20%3 {
+		t.Errorf("tweet is %d after turning forwards 20 steps, want %d", p.currentTweetNumber, 20%3)
+	}
+	for i := 0; i < 22; i++ {
+		counter = (counter - airWheelStep + 256) % 256
+		p.airWheel(counter)
+	}
+	if p.currentTweetNumber != 1 {
+		t.Errorf("tweet is %d after turning back 22 steps, want 1", p.currentTweetNumber)
+	}
+	if p.airWheelCounter < 0 || p.airWheelCounter > 255 {
+		t.Errorf("saved counter is %d, want 0-255", p.airWheelCounter)
+	}
+}
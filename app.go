@@ -1,9 +1,12 @@
 package main
 
-// TODO - Ninja channels stuff so this can be used by other apps/drivers
-
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ChimeraCoder/anaconda"
 	"github.com/lindsaymarkward/go-ninja/config"
@@ -18,97 +21,530 @@ var host = config.String("localhost", "led.host")
 var port = config.Int(3115, "led.remote.port")
 
 // TwitterApp stores the app's core details including the Initialised boolean for whether authentication (API) worked
+// There is one Twitter client per account, keyed by username.
+// Initialised is true if at least one account's API worked, initialised has the status of each account
+// configLock guards config, which is read and changed by the config screens, the pane, the service and the
+// background goroutines. It is always the last lock taken and is never held while calling out to Twitter,
+// so the methods that take it don't call anything else that locks.
+// configSaved is called instead of sending the config to the Sphere to be saved if it's set (for tests),
+// newClient creates the Twitter clients instead of NewTwitterClient and oauthURL is where the OAuth endpoints are
+// instead of Twitter's if they're set (for tests)
 type TwitterApp struct {
 	support.AppSupport
 	led         *remote.Matrix
+	pane        *LEDPane
 	config      *TwitterAppModel
-	twitterAPI  *anaconda.TwitterApi
+	configLock  sync.RWMutex
+	configSaved func(m *TwitterAppModel) error
+	newClient   func(account AccountDetails) TwitterClient
+	oauthURL    string
+	clients     map[string]TwitterClient
+	initialised map[string]bool
+	apiLock     sync.Mutex
+	triggers    triggers
+	scheduler   scheduler
+	queue       sendQueue
+	rateLimits  rateLimits
+	signIns     signIns
+	inbox       inbox
+	history     sentHistory
 	Initialised bool
 }
 
-// Start the app, set up Twitter API, create LED pane
+// Start the app, set up Twitter APIs, create LED pane
 func (a *TwitterApp) Start(m *TwitterAppModel) error {
 	log.Infof("Starting Twitter app with config: %v", m)
+	a.configLock.Lock()
 	a.config = m
+	a.clients = make(map[string]TwitterClient)
+	a.initialised = make(map[string]bool)
 
 	// for clearing tweets (testing)
 	//	a.config.TweetNames = nil
 	//	a.config.Tweets = nil
 
-	// initialise Twitter API and set Initialised state (don't try if account isn't set)
-	if a.config.Account.Username != "" {
-		// check
-		go a.InitTwitterAPI(a.config.Account)
+	// fix anything that doesn't match up, then upgrade configs from older versions
+	// (a config from a newer version of the app is left as it is, it could be "fixed" wrongly)
+	if a.config.isNewer() {
+		log.Errorf("The config is version %d but this version of the app only knows up to %d, it won't be repaired or upgraded", a.config.Version, configVersion)
+		a.config.makeMaps()
 	} else {
-		a.Initialised = false
+		fixes := a.config.repair()
+		for _, fix := range fixes {
+			log.Infof("Repaired config: %s", fix)
+		}
+		if a.config.migrate(false) || len(fixes) > 0 {
+			a.saveConfig()
+		}
+	}
+
+	// initialise Twitter API for each account and set Initialised state
+	a.Initialised = false
+	for _, account := range a.config.Accounts {
+		go a.InitTwitterAPI(account)
 	}
+	a.configLock.Unlock()
+
+	// the pane is made before anything that could send, since snapshots are taken from it
+	log.Infof("Making new pane for Twitter...")
+	a.pane = NewLEDPane(a)
 
 	a.Conn.MustExportService(&ConfigService{a}, "$app/"+a.Info.ID+"/configure", &model.ServiceAnnouncement{
 		Schema: "/protocol/configuration",
 	})
 
-	log.Infof("Making new pane for Twitter...")
-	pane := NewLEDPane(a)
+	// for other apps and drivers to send tweets and direct messages
+	a.Conn.MustExportService(&TwitterService{a}, "$app/"+a.Info.ID+"/service/twitter", &model.ServiceAnnouncement{
+		Schema: "/service/twitter",
+	})
+
+	// subscribe to the device events that trigger stored tweets
+	a.UpdateTriggers()
+	// send scheduled tweets when they're due
+	a.StartScheduler()
+	// retry messages that failed (including ones saved before a restart)
+	a.StartQueue()
+	// get mentions, direct messages and tweets (if turned on)
+	a.StartPolling()
 
 	// Export our newly made pane
-	a.led = remote.NewTCPMatrix(pane, fmt.Sprintf("%s:%d", host, port))
+	a.led = remote.NewTCPMatrix(a.pane, fmt.Sprintf("%s:%d", host, port))
 
 	return nil
 }
 
-// Stop the app - sort of, not really
+// Stop the app - sort of, not really (just stops the scheduler, queue and polling)
 func (a *TwitterApp) Stop() error {
+	a.StopScheduler()
+	a.StopQueue()
+	a.StopPolling()
 	return nil
 }
 
-// SaveAccount saves the account to the config and initialises the Twitter API
-func (a *TwitterApp) SaveAccount(account AccountDetails) error {
+// Config returns a copy of the config that can be read without locking
+func (a *TwitterApp) Config() TwitterAppModel {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.Copy()
+}
+
+// updateConfig calls change with the config locked then saves the config (unless change returns an error)
+// change must not call anything that locks
+func (a *TwitterApp) updateConfig(change func(m *TwitterAppModel) error) error {
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+	if err := change(a.config); err != nil {
+		return err
+	}
+	return a.saveConfig()
+}
+
+// errUnchanged is returned by an updateConfig change that didn't change anything, so the config isn't saved
+var errUnchanged = errors.New("nothing changed")
+
+// saveConfig sends the config to the Sphere to be saved, or to configSaved if it's set (config lock must be held)
+func (a *TwitterApp) saveConfig() error {
+	if a.configSaved != nil {
+		return a.configSaved(a.config)
+	}
+	return a.SendEvent("config", a.config)
+}
+
+// SaveAccount saves the account to the config (with its secrets encrypted) and initialises the Twitter API for it
+// previous is the username the account had before editing (blank for a new account) so renames replace the old entry
+// It returns an error if another account already has the username
+func (a *TwitterApp) SaveAccount(account AccountDetails, previous string, makeDefault bool) error {
 	log.Infof("Saving account with username %v\n", account.Username)
 
-	// for multiple accounts...
-	//	if a.config.Accounts == nil {
-	//		a.config.Accounts = make(map[string]AccountDetails)
-	//	}
-	//	a.config.Accounts[account.Username] = account
+	account, err := account.Encrypted()
+	if err != nil {
+		return fmt.Errorf("could not encrypt secrets: %v", err)
+	}
+
+	renamed := previous != "" && previous != account.Username
+	err = a.updateConfig(func(m *TwitterAppModel) error {
+		if _, ok := m.Accounts[account.Username]; ok && account.Username != previous {
+			return fmt.Errorf("there is already an account called %s", account.Username)
+		}
+		if renamed {
+			m.removeAccount(previous, account.Username)
+		}
+		m.Accounts[account.Username] = account
+		if makeDefault || m.DefaultAccount == "" {
+			m.DefaultAccount = account.Username
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if renamed {
+		a.removeClient(previous)
+	}
 
-	a.config.Account = account
 	// create Twitter API (anaconda) object
 	a.InitTwitterAPI(account)
-	return a.SendEvent("config", a.config)
+	return nil
 }
 
-// InitTwitterAPI creates a new Twitter API object using the account details
+// DeleteAccount removes the account and its API, tweets that used it will use the default account
+func (a *TwitterApp) DeleteAccount(username string) error {
+	log.Infof("Deleting account with username %v\n", username)
+	err := a.updateConfig(func(m *TwitterAppModel) error {
+		m.removeAccount(username, "")
+		return nil
+	})
+	a.removeClient(username)
+	return err
+}
+
+// removeClient closes and deletes the Twitter client for username (when its account is removed)
+func (a *TwitterApp) removeClient(username string) {
+	a.apiLock.Lock()
+	defer a.apiLock.Unlock()
+	if client, ok := a.clients[username]; ok {
+		closeClient(client)
+	}
+	delete(a.clients, username)
+	delete(a.initialised, username)
+	a.updateInitialised()
+}
+
+// AccountNames returns the usernames of all accounts in alphabetical order
+func (a *TwitterApp) AccountNames() []string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.AccountNames()
+}
+
+// Account returns the saved details (with encrypted secrets) of the account with username
+func (a *TwitterApp) Account(username string) (AccountDetails, bool) {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	account, ok := a.config.Accounts[username]
+	return account, ok
+}
+
+// StoredTweet returns the stored tweet called name
+func (a *TwitterApp) StoredTweet(name string) (TweetDetails, bool) {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	tweet, ok := a.config.Tweets[name]
+	return tweet.Copy(), ok
+}
+
+// storedTweetName returns the current name of the stored tweet with id (blank if there isn't one)
+func (a *TwitterApp) storedTweetName(id string) string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.tweetNameByID(id)
+}
+
+// TweetNames returns the names of the stored tweets in order
+func (a *TwitterApp) TweetNames() []string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return append([]string(nil), a.config.TweetNames...)
+}
+
+// AccountFor returns the username that tweet should be sent from - its own account if that exists, or the default
+func (a *TwitterApp) AccountFor(tweet TweetDetails) string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.AccountFor(tweet)
+}
+
+// IsInitialised returns whether the API for the account with username worked
+func (a *TwitterApp) IsInitialised(username string) bool {
+	a.apiLock.Lock()
+	defer a.apiLock.Unlock()
+	return a.initialised[username]
+}
+
+// InitTwitterAPI creates a new Twitter client using the (saved) account details and checks that it works
 func (a *TwitterApp) InitTwitterAPI(account AccountDetails) error {
-	anaconda.SetConsumerKey(account.ConsumerKey)
-	anaconda.SetConsumerSecret(account.ConsumerSecret)
-	a.twitterAPI = anaconda.NewTwitterApi(account.AccessToken, account.AccessTokenSecret)
-	user, err := a.twitterAPI.GetSelf(nil)
+	account, err := account.Decrypted()
+	if err != nil {
+		log.Errorf("Error initialising Twitter API for %v: %v", account.Username, err)
+		a.setClient(account.Username, nil)
+		return err
+	}
+	// check it works without holding the lock, so other accounts can be used meanwhile
+	var client TwitterClient
+	if a.newClient != nil {
+		client = a.newClient(account)
+	} else {
+		client = NewTwitterClient(account)
+	}
+	var user anaconda.User
+	err = a.callAPI(account.Username, EndpointVerify, client, func() (err error) {
+		user, err = client.GetSelf()
+		return err
+	})
 	if err != nil {
-		log.Infof("Error initialising Twitter API: %v", err)
-		a.Initialised = false
+		log.Infof("Error initialising Twitter API for %v: %v", account.Username, err)
+		closeClient(client)
+		a.setClient(account.Username, nil)
 		return err
 	}
 	log.Infof("Initialised Twitter API with username: %v", user.ScreenName)
-	a.Initialised = true
+	a.setClient(account.Username, client)
 	return nil
 }
 
-// PostTweet sends message as a regular public tweet
-func (a *TwitterApp) PostTweet(message string) error {
-	_, err := a.twitterAPI.PostTweet(message, nil)
+// setClient stores the working Twitter client for username (closing the one it replaces),
+// or marks it as not working if client is nil
+func (a *TwitterApp) setClient(username string, client TwitterClient) {
+	a.apiLock.Lock()
+	defer a.apiLock.Unlock()
+	if client != nil {
+		if previous, ok := a.clients[username]; ok && previous != client {
+			closeClient(previous)
+		}
+		a.clients[username] = client
+	}
+	a.initialised[username] = client != nil
+	a.updateInitialised()
+}
+
+// IsReady returns whether at least one account's API worked
+func (a *TwitterApp) IsReady() bool {
+	a.apiLock.Lock()
+	defer a.apiLock.Unlock()
+	return a.Initialised
+}
+
+// updateInitialised sets Initialised if any account's API worked (apiLock must be held)
+func (a *TwitterApp) updateInitialised() {
+	a.Initialised = false
+	for _, ok := range a.initialised {
+		if ok {
+			a.Initialised = true
+		}
+	}
+}
+
+// Client returns the Twitter client for the account with username, if it's set up and working
+func (a *TwitterApp) Client(username string) (TwitterClient, error) {
+	a.apiLock.Lock()
+	defer a.apiLock.Unlock()
+
+	client, ok := a.clients[username]
+	if !ok || !a.initialised[username] {
+		return nil, fmt.Errorf("Twitter account %q is not set up", username)
+	}
+	return client, nil
+}
+
+// PostTweet sends message as a regular public tweet from account (unless its rate limit has been reached)
+// media is an image file path or URL to attach (blank for none)
+// It returns the new status's ID (for deleting it)
+func (a *TwitterApp) PostTweet(account, message, media string) (string, error) {
+	client, err := a.Client(account)
+	if err == nil {
+		// checked before uploading so the image isn't uploaded for a tweet that can't be sent
+		err = a.checkRateLimit(account, EndpointTweet)
+	}
+	v := url.Values{}
+	if err == nil && media != "" {
+		err = a.callAPI(account, EndpointMediaUpload, client, func() error {
+			mediaID, err := uploadMedia(client, media)
+			v.Set("media_ids", mediaID)
+			return err
+		})
+	}
+	var tweet anaconda.Tweet
+	if err == nil {
+		err = a.callAPI(account, EndpointTweet, client, func() (err error) {
+			tweet, err = client.PostTweet(message, v)
+			return err
+		})
+	}
 	if err != nil {
 		log.Errorf("Error posting Tweet: %v", err)
 		//		log.Infof("Twitter API result: %#v", result)
+		return "", err
 	}
-	return err
+	return tweet.IdStr, nil
+}
+
+// DeleteTweet deletes account's status with id (for undoing sending it)
+func (a *TwitterApp) DeleteTweet(account, id string) error {
+	statusID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid status ID %s", id)
+	}
+	client, err := a.Client(account)
+	if err == nil {
+		err = a.callAPI(account, EndpointDelete, client, func() error {
+			_, err := client.DeleteTweet(statusID)
+			return err
+		})
+	}
+	if err != nil {
+		log.Errorf("Error deleting Tweet %s: %v", id, err)
+		return err
+	}
+	log.Infof("Deleted Tweet %s from %s", id, account)
+	return nil
 }
 
-// PostDirectMessage sends message to user as a direct message
-func (a *TwitterApp) PostDirectMessage(message, user string) error {
-	_, err := a.twitterAPI.PostDMToScreenName(message, user)
+// PostDirectMessage sends message to user as a direct message from account (unless its rate limit has been reached)
+func (a *TwitterApp) PostDirectMessage(account, message, user string) error {
+	client, err := a.Client(account)
+	if err == nil {
+		err = a.callAPI(account, EndpointDirectMessage, client, func() error {
+			_, err := client.PostDM(message, user)
+			return err
+		})
+	}
 	if err != nil {
 		log.Errorf("Error sending direct message: %v", err)
 		//		log.Infof("Twitter API result: %#v", result)
 	}
 	return err
 }
+
+// Send posts message as a public tweet from account, or as a direct message if to is set
+// media is an image file path or URL to attach to a tweet (blank for none)
+// The result describes the attempt, including the error text if it failed.
+// If it failed with a temporary error (e.g. no network) it is queued to be retried
+func (a *TwitterApp) Send(account, to, message, media string) (*SendResult, error) {
+	result, err := a.send(TweetDetails{}, account, to, message, media)
+	a.recordSent("", 1, result)
+	return result, err
+}
+
+// send is Send for the stored tweet (empty if it's not a stored tweet), without recording it in the history
+func (a *TwitterApp) send(tweet TweetDetails, account, to, message, media string) (*SendResult, error) {
+	id, err := a.post(account, to, message, media)
+	result := &SendResult{
+		Success: err == nil,
+		ID:      id,
+		Account: account,
+		To:      to,
+		Message: message,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		if IsTransient(err) {
+			a.Enqueue(tweet, account, to, message, media, err)
+			result.Queued = true
+		}
+	}
+	if !result.Queued {
+		removeSnapshot(media)
+	}
+	return result, err
+}
+
+// post sends message as a public tweet from account, or as a direct message if to is set
+// (direct messages can't have media so it's ignored for them)
+// It returns the tweet's ID, or blank for direct messages
+func (a *TwitterApp) post(account, to, message, media string) (string, error) {
+	// the account may not have been set up if the network was down when the app started
+	if details, ok := a.Account(account); ok && !a.IsInitialised(account) {
+		if err := a.InitTwitterAPI(details); err != nil {
+			return "", err
+		}
+	}
+	if to == "" {
+		return a.PostTweet(account, message, media)
+	}
+	if media != "" {
+		log.Infof("Direct messages can't have media, sending the message without %s", media)
+	}
+	return "", a.PostDirectMessage(account, message, to)
+}
+
+// SendStoredTweet sends the stored tweet/message called name, rendering its message template first
+// Its variation is incremented first and used to make the message unique (see makeUnique), its count is only
+// incremented once it has been sent (including when it was queued and a retry works).
+// If Twitter still rejects it as a duplicate, the next variations are tried (unless its strategy is UniqueNone),
+// and only the final result is recorded in the history
+// event is the payload of the device event that triggered it, or nil
+func (a *TwitterApp) SendStoredTweet(name string, event interface{}) (*SendResult, error) {
+	// update config to save the count
+	var tweet TweetDetails
+	var account string
+	err := a.updateConfig(func(m *TwitterAppModel) error {
+		var ok bool
+		tweet, ok = m.Tweets[name]
+		if !ok {
+			return fmt.Errorf("There is no stored tweet called %s", name)
+		}
+		tweet.Variation++
+		m.Tweets[name] = tweet
+		account = m.AccountFor(tweet)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error sending %v: %v", name, err)
+		result, err := failed(&SendResult{}, err)
+		a.recordSent(name, 1, result)
+		return result, err
+	}
+	// the message has the count it will have once it's sent
+	tweet.Number++
+	log.Infof("Tweeting: %v to %v from %v (%v)", tweet.Message, tweet.To, account, tweet.Number)
+
+	for retry := 0; ; retry++ {
+		result, err := a.sendVariation(name, account, tweet, event, retry > 0)
+		if !IsDuplicate(err) || tweet.uniqueness() == UniqueNone || retry >= maxVariationRetries {
+			a.recordSent(name, 1, result)
+			a.finishStoredTweet(tweet.ID, tweet.Variation, result)
+			return result, err
+		}
+		tweet.Variation++
+		log.Infof("%v was rejected as a duplicate, trying variation %d", name, tweet.Variation)
+	}
+}
+
+// finishStoredTweet saves the last variation of the stored tweet with id that was tried,
+// and counts it and saves when it was sent if it worked (it's found by ID as it may have been renamed meanwhile)
+func (a *TwitterApp) finishStoredTweet(id string, variation int, result *SendResult) {
+	a.updateConfig(func(m *TwitterAppModel) error {
+		name := m.tweetNameByID(id)
+		tweet, ok := m.Tweets[name]
+		if !ok {
+			return fmt.Errorf("There is no stored tweet with ID %s", id)
+		}
+		if variation > tweet.Variation {
+			tweet.Variation = variation
+		}
+		if result != nil && result.Success {
+			tweet.Number++
+			tweet.LastSent = result.Time
+		}
+		m.Tweets[name] = tweet
+		return nil
+	})
+}
+
+// sendVariation renders and sends tweet (the stored tweet called name) from account, with a new snapshot if it has one
+// retrying is true when the last variation was rejected as a duplicate
+func (a *TwitterApp) sendVariation(name, account string, tweet TweetDetails, event interface{}, retrying bool) (*SendResult, error) {
+	message, err := renderMessage(tweet, NewTemplateData(tweet, time.Now(), event), templateFuncs, retrying)
+	if err != nil {
+		log.Errorf("Error rendering message for %v: %v", name, err)
+		return failed(&SendResult{Account: account, To: tweet.To, Message: tweet.Message}, err)
+	}
+
+	media := tweet.Media
+	if media == MediaSnapshot && tweet.To == "" {
+		media, err = a.Snapshot()
+		if err != nil {
+			log.Errorf("Error taking snapshot for %v: %v", name, err)
+			return failed(&SendResult{Account: account, Message: message}, err)
+		}
+	}
+	return a.send(tweet, account, tweet.To, message, media)
+}
+
+// failed fills in result for a stored tweet that couldn't be sent because of err, and returns it with err
+func failed(result *SendResult, err error) (*SendResult, error) {
+	result.Error = err.Error()
+	result.Time = time.Now()
+	return result, err
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/ninjasphere/go-ninja/model"
+)
+
+func TestMain(m *testing.M) {
+	// the secrets key and history are kept out of the working directory
+	dir, err := ioutil.TempDir("", "app-twitter-test")
+	if err != nil {
+		panic(err)
+	}
+	keyFile = filepath.Join(dir, "twitter.key")
+	historyFile = filepath.Join(dir, "twitter-history.json")
+	snapshotDir = filepath.Join(dir, "snapshots")
+	// the tests update the pane's status themselves instead of it happening on a timer
+	tickInterval = time.Hour
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestApp makes an app with the account @me on server and the stored tweets, without connecting to the Sphere
+// (the config isn't sent anywhere when it's saved)
+func newTestApp(t *testing.T, server *twitterServer, tweets ...TweetDetails) *TwitterApp {
+	historyFile = filepath.Join(t.TempDir(), "twitter-history.json")
+	a := &TwitterApp{
+		config: &TwitterAppModel{
+			Version:  configVersion,
+			Accounts: make(map[string]AccountDetails),
+			Tweets:   make(map[string]TweetDetails),
+		},
+		clients:     make(map[string]TwitterClient),
+		initialised: make(map[string]bool),
+		newClient:   server.newClient,
+	}
+	a.configSaved = func(m *TwitterAppModel) error {
+		return nil
+	}
+	for _, tweet := range tweets {
+		if err := a.config.saveTweet(tweet); err != nil {
+			t.Fatalf("Could not store %s: %v", tweet.Name, err)
+		}
+	}
+	if err := a.SaveAccount(server.addUser("@me"), "", true); err != nil {
+		t.Fatalf("Could not save account: %v", err)
+	}
+	if !a.IsInitialised("@me") {
+		t.Fatalf("Account @me wasn't initialised")
+	}
+	return a
+}
+
+func TestSendStoredTweet(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+
+	result, err := a.SendStoredTweet("hello", nil)
+	if err != nil || !result.Success || result.ID == "" {
+		t.Fatalf("SendStoredTweet returned %+v, %v", result, err)
+	}
+	if tweets := server.tweeted("@me"); !reflect.DeepEqual(tweets, []string{"Hello 1"}) {
+		t.Errorf("tweets are %q, want the message with the counter", tweets)
+	}
+	tweet, _ := a.StoredTweet("hello")
+	if tweet.Number != 1 || tweet.LastSent.IsZero() {
+		t.Errorf("after sending, count is %d and last sent is %v", tweet.Number, tweet.LastSent)
+	}
+	history := a.History()
+	if len(history) != 1 || !history[0].Success || history[0].Tweet != "hello" || history[0].ID != result.ID {
+		t.Errorf("history is %+v, want the sent tweet", history)
+	}
+
+	if _, err := a.SendStoredTweet("missing", nil); err == nil {
+		t.Errorf("sending a tweet that isn't stored worked")
+	}
+}
+
+func TestSendStoredTweetDuplicate(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	// sent some other way, so the first variation is a duplicate
+	if _, err := a.PostTweet("@me", "Hello 1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := a.SendStoredTweet("hello", nil)
+	if err != nil || !result.Success {
+		t.Fatalf("SendStoredTweet returned %+v, %v", result, err)
+	}
+	if tweets := server.tweeted("@me"); !reflect.DeepEqual(tweets, []string{"Hello 1", "Hello 2"}) {
+		t.Errorf("tweets are %q, want the next variation after the duplicate", tweets)
+	}
+	if tweet, _ := a.StoredTweet("hello"); tweet.Variation != 2 || tweet.Number != 1 {
+		t.Errorf("variation is %d and count is %d, want 2 and 1", tweet.Variation, tweet.Number)
+	}
+	if history := a.History(); len(history) != 1 || !history[0].Success || history[0].Message != "Hello 2" {
+		t.Errorf("history is %+v, want only the variation that was sent", history)
+	}
+}
+
+func TestSendStoredTweetTimestamp(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello", Unique: UniqueTimestamp})
+	for i := 0; i < 3; i++ {
+		if _, err := a.SendStoredTweet("hello", nil); err != nil {
+			t.Fatalf("sending it again straight away returned %v", err)
+		}
+	}
+	if history := a.History(); len(history) != 3 {
+		t.Errorf("history is %+v, want the 3 sends", history)
+	}
+}
+
+func TestSendStoredTweetNotUnique(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello", Unique: UniqueNone})
+	if _, err := a.SendStoredTweet("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := a.SendStoredTweet("hello", nil)
+	if !IsDuplicate(err) || result.Success || result.Queued {
+		t.Errorf("sending it again returned %+v, %v, want a duplicate error", result, err)
+	}
+	if tweet, _ := a.StoredTweet("hello"); tweet.Number != 1 {
+		t.Errorf("count is %d after sending once and failing once, want 1", tweet.Number)
+	}
+	if a.PendingCount() != 0 {
+		t.Errorf("%d messages were queued, duplicates shouldn't be", a.PendingCount())
+	}
+}
+
+func TestSendStoredTweetQueued(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+
+	result, err := a.SendStoredTweet("hello", nil)
+	if err == nil || !result.Queued {
+		t.Fatalf("SendStoredTweet while Twitter is down returned %+v, %v, want it queued", result, err)
+	}
+	if a.PendingCount() != 1 {
+		t.Fatalf("%d messages are queued, want 1", a.PendingCount())
+	}
+	if tweet, _ := a.StoredTweet("hello"); tweet.Number != 0 {
+		t.Errorf("count is %d before it has been sent, want 0", tweet.Number)
+	}
+
+	// it's not due yet
+	a.RetryQueue(false)
+	if count := server.requestCount(EndpointTweet); count != 1 {
+		t.Errorf("tweet was posted %d times before it was due to be retried", count)
+	}
+
+	server.clear(EndpointTweet)
+	a.RetryQueue(true)
+	if tweets := server.tweeted("@me"); !reflect.DeepEqual(tweets, []string{"Hello 1"}) {
+		t.Errorf("tweets after retrying are %q", tweets)
+	}
+	if a.PendingCount() != 0 {
+		t.Errorf("%d messages are still queued after sending", a.PendingCount())
+	}
+	if history := a.History(); len(history) == 0 || !history[0].Success || history[0].Attempts != 2 || history[0].Tweet != "hello" {
+		t.Errorf("history is %+v, want the retry that worked first", history)
+	}
+	if tweet, _ := a.StoredTweet("hello"); tweet.Number != 1 || tweet.LastSent.IsZero() {
+		t.Errorf("after the retry worked, count is %d and last sent is %v", tweet.Number, tweet.LastSent)
+	}
+}
+
+func TestRetryQueueAfterRename(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+	a.SendStoredTweet("hello", nil)
+
+	tweet, _ := a.StoredTweet("hello")
+	tweet.Name = "greeting"
+	if err := a.updateConfig(func(m *TwitterAppModel) error { return m.saveTweet(tweet) }); err != nil {
+		t.Fatal(err)
+	}
+	server.clear(EndpointTweet)
+	a.RetryQueue(true)
+	if tweet, _ := a.StoredTweet("greeting"); tweet.Number != 1 || tweet.LastSent.IsZero() {
+		t.Errorf("after the retry of a renamed tweet worked, count is %d and last sent is %v", tweet.Number, tweet.LastSent)
+	}
+	if history := a.History(); len(history) == 0 || history[0].Tweet != "greeting" {
+		t.Errorf("history is %+v, want the retry first with the new name", history)
+	}
+}
+
+func TestRetryQueueKeepsTransientFailures(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+	a.SendStoredTweet("hello", nil)
+
+	a.RetryQueue(true)
+	pending := a.PendingMessages()
+	if len(pending) != 1 || pending[0].Attempts != 2 || !pending[0].NextTry.After(time.Now()) {
+		t.Errorf("queue after failing again is %+v, want it retried later", pending)
+	}
+}
+
+func TestRetryQueueDropsPermanentFailures(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+	a.SendStoredTweet("hello", nil)
+
+	// e.g. the account has been suspended since
+	server.fail(EndpointTweet, http.StatusForbidden, 64, nil)
+	a.RetryQueue(true)
+	if a.PendingCount() != 0 {
+		t.Errorf("%d messages are still queued after failing permanently", a.PendingCount())
+	}
+	if history := a.History(); len(history) == 0 || history[0].Success || history[0].Queued {
+		t.Errorf("history is %+v, want the failed retry first", history)
+	}
+}
+
+func TestRetryQueueDropsDeletedAccounts(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+	a.SendStoredTweet("hello", nil)
+	server.clear(EndpointTweet)
+
+	a.DeleteAccount("@me")
+	a.RetryQueue(true)
+	if a.PendingCount() != 0 || len(server.tweeted("@me")) != 0 {
+		t.Errorf("queued message for a deleted account wasn't dropped")
+	}
+	if history := a.History(); len(history) == 0 || !strings.Contains(history[0].Error, "no longer exists") {
+		t.Errorf("history is %+v, want the dropped message first", history)
+	}
+}
+
+func TestRetryQueueSendsUnlocked(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+	a.SendStoredTweet("hello", nil)
+	server.clear(EndpointTweet)
+
+	release := server.block(EndpointTweet)
+	defer release()
+	retried := make(chan bool)
+	go func() {
+		a.RetryQueue(true)
+		close(retried)
+	}()
+	for server.requestCount(EndpointTweet) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// while it's sending, messages can be queued and retried (but not the one being sent)
+	// (the queued one is for an account that has gone, so retrying it doesn't wait to use the API)
+	queued := make(chan bool)
+	go func() {
+		a.Enqueue(TweetDetails{}, "@gone", "", "Later", "", &net.OpError{Op: "dial", Err: errors.New("no network")})
+		a.RetryQueue(true)
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("queueing waited for the retry to send")
+	}
+	if a.PendingCount() != 1 {
+		t.Errorf("%d messages are queued while retrying, want the one being sent", a.PendingCount())
+	}
+
+	release()
+	<-retried
+	if tweets := server.tweeted("@me"); !reflect.DeepEqual(tweets, []string{"Hello 1"}) {
+		t.Errorf("tweets are %q, want the queued tweet sent once", tweets)
+	}
+	if a.PendingCount() != 0 {
+		t.Errorf("%d messages are still queued", a.PendingCount())
+	}
+}
+
+func TestRetryQueueKeepsDeletedDeleted(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.fail(EndpointTweet, http.StatusServiceUnavailable, 130, nil)
+	a.SendStoredTweet("hello", nil)
+
+	release := server.block(EndpointTweet)
+	defer release()
+	retried := make(chan bool)
+	go func() {
+		a.RetryQueue(true)
+		close(retried)
+	}()
+	for server.requestCount(EndpointTweet) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	a.DeleteQueued(a.PendingMessages()[0].ID)
+	release()
+	<-retried
+	if a.PendingCount() != 0 {
+		t.Errorf("message deleted while it was retried (and failed) was queued again")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&RateLimitError{Account: "@me", Endpoint: EndpointTweet}, true},
+		{&anaconda.ApiError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&anaconda.ApiError{StatusCode: http.StatusTooManyRequests}, true},
+		{duplicateError(), false},
+		{&anaconda.ApiError{StatusCode: http.StatusUnauthorized}, false},
+		{&MediaError{"missing.png", errors.New("no such file")}, false},
+		{fmt.Errorf("Twitter account %q is not set up", "@me"), false},
+	} {
+		if got := IsTransient(test.err); got != test.want {
+			t.Errorf("IsTransient(%v) is %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestSendSetsUpAccount(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	// as if the network was down when the app started
+	a.setClient("@me", nil)
+
+	if _, err := a.Send("@me", "", "Hello", ""); err != nil {
+		t.Errorf("sending from an account that wasn't set up returned %v", err)
+	}
+	if _, err := a.Send("@nobody", "", "Hello", ""); err == nil || IsTransient(err) || a.PendingCount() != 0 {
+		t.Errorf("sending from an account that doesn't exist returned %v and queued %d", err, a.PendingCount())
+	}
+}
+
+func TestSendDirectMessage(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hi", Message: "Hi", To: "@you"})
+
+	result, err := a.SendStoredTweet("hi", nil)
+	if err != nil || !result.Success || result.To != "@you" {
+		t.Fatalf("SendStoredTweet returned %+v, %v", result, err)
+	}
+	if messages := server.sentMessages("@me"); !reflect.DeepEqual(messages, []string{"Hi 1"}) {
+		t.Errorf("direct messages are %q", messages)
+	}
+	if len(server.tweeted("@me")) != 0 {
+		t.Errorf("the direct message was tweeted")
+	}
+}
+
+// TestConcurrentUse uses the pane, config screens and service at the same time, for the race detector
+func TestConcurrentUse(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello {{.Count}}"},
+		TweetDetails{Name: "other", Message: "Other"})
+	a.config.Timeline = TimelineSettings{Enabled: true, Mentions: true}
+	a.inbox.sinceIDs = make(map[string]string)
+	p := newTestPane(a)
+	c := &ConfigService{a}
+	s := &TwitterService{a}
+	hello, _ := a.StoredTweet("hello")
+	server.mention("@me", "@you", "@me hi")
+
+	const times = 20
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < times; i++ {
+				f(i)
+			}
+		}()
+	}
+	run(func(i int) {
+		paneAction(p, ActionNext)
+		p.Render()
+		p.UpdateStatus()
+	})
+	run(func(i int) {
+		data := fmt.Sprintf(`{"id": %q, "name": "hello", "message": "Hello {{.Count}}", "triggertopic": "topic %d",
+			"schedulecron": "0 7 * * *"}`, hello.ID, i)
+		if _, err := c.Configure(&model.ConfigurationRequest{Action: "saveTweet", Data: []byte(data)}); err != nil {
+			t.Errorf("saving the tweet returned %v", err)
+		}
+	})
+	run(func(i int) {
+		if result, err := s.PostTweet(&MessageRequest{Message: fmt.Sprintf("Posted %d", i)}); err != nil || !result.Success {
+			t.Errorf("posting returned %+v, %v", result, err)
+		}
+		s.SendStoredTweet(&StoredTweetRequest{Name: "hello"})
+	})
+	run(func(i int) {
+		if tweet, ok := a.Config().Tweets["hello"]; ok && tweet.Trigger != nil {
+			_ = tweet.Trigger.Topic
+		}
+		a.StoredTweet("hello")
+		a.Poll()
+		a.Unread()
+		a.RetryQueue(true)
+	})
+	wg.Wait()
+
+	if tweets := server.tweeted("@me"); len(tweets) != 2*times {
+		t.Errorf("%d tweets were sent, want %d", len(tweets), 2*times)
+	}
+	if tweet, _ := a.StoredTweet("hello"); tweet.Number != times || tweet.Trigger == nil {
+		t.Errorf("hello is %+v after sending it %d times while editing it", tweet, times)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// commandTimeout is how long to wait for a Ninja service to reply to a forwarded command
+var commandTimeout = time.Second * 10
+
+// commandHelp is the reply to "help"
+const commandHelp = "Commands: status, list, send <number or name>, call <topic> <method> [json params]"
+
+// command is a direct message to account that hasn't been run yet
+type command struct {
+	account string
+	message anaconda.DirectMessage
+}
+
+// newCommands returns the direct messages (newest first, by account) that are newer than the newest one already
+// checked for commands for their account, oldest first so commands run in the order they were sent. The newest IDs
+// are saved (with one save) before returning, so they aren't run again if the app stops part way through running them.
+// The first time an account is checked (after commands are turned on or it's added) none are returned, since they
+// were sent before commands were being checked
+func (a *TwitterApp) newCommands(received map[string][]anaconda.DirectMessage) []command {
+	var commands []command
+	a.updateConfig(func(m *TwitterAppModel) error {
+		if m.Commands.LastIDs == nil {
+			m.Commands.LastIDs = make(map[string]int64)
+		}
+		changed := false
+		for account, messages := range received {
+			last, checked := m.Commands.LastIDs[account]
+			newest := last
+			for i := len(messages) - 1; i >= 0; i-- {
+				if messages[i].Id <= last {
+					continue
+				}
+				if checked {
+					commands = append(commands, command{account, messages[i]})
+				}
+				newest = messages[i].Id
+			}
+			if !checked || newest != last {
+				m.Commands.LastIDs[account] = newest
+				changed = true
+			}
+		}
+		if !changed {
+			return errUnchanged
+		}
+		return nil
+	})
+	return commands
+}
+
+// handleCommands runs the commands sent by allowed users and replies to them
+func (a *TwitterApp) handleCommands(commands []command) {
+	for _, command := range commands {
+		account, message := command.account, command.message
+		sender := addAt(message.SenderScreenName)
+		if !a.isAllowed(sender) {
+			continue
+		}
+
+		log.Infof("Command from %s to %s: %s", sender, account, message.Text)
+		reply := a.RunCommand(message.Text)
+		// the time makes each reply different so Twitter won't reject repeated replies as duplicates
+		reply = fmt.Sprintf("%s (%s)", reply, time.Now().Format("15:04:05"))
+		if _, err := a.Send(account, sender, reply, ""); err != nil {
+			log.Errorf("Could not reply to command from %s: %v", sender, err)
+		}
+	}
+}
+
+// RunCommand runs a command and returns the reply
+func (a *TwitterApp) RunCommand(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return commandHelp
+	}
+	args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), fields[0]))
+
+	switch strings.ToLower(fields[0]) {
+	case "status":
+		var accounts []string
+		for _, username := range a.AccountNames() {
+			status := "OK"
+			if !a.IsInitialised(username) {
+				status = "not working"
+			}
+			accounts = append(accounts, username+" "+status)
+		}
+		return fmt.Sprintf("Accounts: %s. %d stored tweets, %d waiting to send, %d unread",
+			strings.Join(accounts, ", "), len(a.TweetNames()), a.PendingCount(), len(a.Unread()))
+
+	case "list":
+		var names []string
+		for i, name := range a.TweetNames() {
+			names = append(names, fmt.Sprintf("%d-%s", i+1, name))
+		}
+		if len(names) == 0 {
+			return "No stored tweets"
+		}
+		return strings.Join(names, ", ")
+
+	case "send":
+		name := args
+		// a number is the position in the list (like on the spheramid)
+		names := a.TweetNames()
+		if number, err := strconv.Atoi(args); err == nil && number >= 1 && number <= len(names) {
+			name = names[number-1]
+		}
+		if _, ok := a.StoredTweet(name); !ok {
+			return fmt.Sprintf("No stored tweet %q", args)
+		}
+		result, err := a.SendStoredTweet(name, nil)
+		switch {
+		case err == nil:
+			return fmt.Sprintf("Sent %s", name)
+		case result != nil && result.Queued:
+			return fmt.Sprintf("%s is queued to retry: %v", name, err)
+		default:
+			return fmt.Sprintf("Could not send %s: %v", name, err)
+		}
+
+	case "call":
+		// forward to a Ninja service, e.g. call $device/<id>/channel/<channel> turnOn
+		callFields := strings.SplitN(args, " ", 3)
+		if len(callFields) < 2 {
+			return "Usage: call <topic> <method> [json params]"
+		}
+		var params interface{}
+		if len(callFields) == 3 {
+			if err := json.Unmarshal([]byte(callFields[2]), &params); err != nil {
+				return fmt.Sprintf("Params must be JSON: %v", err)
+			}
+		}
+		var reply interface{}
+		err := a.Conn.GetServiceClient(callFields[0]).Call(callFields[1], params, &reply, commandTimeout)
+		if err != nil {
+			return fmt.Sprintf("Call failed: %v", err)
+		}
+		if reply == nil {
+			return "Done"
+		}
+		replyJSON, _ := json.Marshal(reply)
+		return fmt.Sprintf("Done: %s", replyJSON)
+
+	default:
+		return commandHelp
+	}
+}
+
+// isAllowed returns whether user is on the allow list for sending commands
+func (a *TwitterApp) isAllowed(user string) bool {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	for _, allowed := range a.config.Commands.AllowList {
+		if strings.EqualFold(addAt(allowed), user) {
+			return true
+		}
+	}
+	return false
+}
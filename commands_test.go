@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPollSkipsOldCommands(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	c := &ConfigService{a}
+	a.inbox.sinceIDs = make(map[string]string)
+	// sent before commands were turned on
+	server.directMessage("@me", "@you", "send 1")
+	server.directMessage("@me", "@you", "status")
+
+	configure(t, c, "saveCommands", map[string]interface{}{"enabled": true, "allowlist": "you"})
+	a.Poll()
+	a.Poll()
+	if replies := server.sentMessages("@me"); len(replies) != 0 || len(server.tweeted("@me")) != 0 {
+		t.Fatalf("old commands were run, replies are %q", replies)
+	}
+
+	server.directMessage("@me", "@you", "list")
+	a.Poll()
+	if replies := server.sentMessages("@me"); len(replies) != 1 || !strings.HasPrefix(replies[0], "1-hello") {
+		t.Errorf("replies are %q, want one to list", replies)
+	}
+
+	// turning commands off and on again doesn't run the ones in between
+	configure(t, c, "saveCommands", map[string]interface{}{"enabled": false, "allowlist": "you"})
+	server.directMessage("@me", "@you", "send 1")
+	configure(t, c, "saveCommands", map[string]interface{}{"enabled": true, "allowlist": "you"})
+	a.Poll()
+	a.Poll()
+	if replies := server.sentMessages("@me"); len(replies) != 1 || len(server.tweeted("@me")) != 0 {
+		t.Errorf("commands sent while they were off were run, replies are %q", replies)
+	}
+}
+
+func TestPollRunsMissedCommands(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	server.directMessage("@me", "@you", "list")
+	// list was run before the app stopped
+	a.config.Commands = CommandSettings{Enabled: true, AllowList: []string{"you"},
+		LastIDs: map[string]int64{"@me": server.received["me"][0].Id}}
+	server.directMessage("@me", "@you", "status")
+	server.directMessage("@me", "@stranger", "send 1")
+	a.inbox.sinceIDs = make(map[string]string)
+	saves := 0
+	a.configSaved = func(m *TwitterAppModel) error {
+		saves++
+		return nil
+	}
+
+	a.Poll()
+	replies := server.sentMessages("@me")
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "Accounts:") {
+		t.Fatalf("replies are %q, want only the one to status", replies)
+	}
+	if len(server.tweeted("@me")) != 0 {
+		t.Errorf("a command from someone who isn't allowed was run")
+	}
+	if last := a.Config().Commands.LastIDs["@me"]; last != server.received["me"][2].Id || saves != 1 {
+		t.Errorf("last checked ID is %d after %d saves, want the newest after one save", last, saves)
+	}
+
+	a.Poll()
+	if saves != 1 || len(server.sentMessages("@me")) != 1 {
+		t.Errorf("polling without new messages saved the config or ran commands again")
+	}
+}
+
+func TestImportSkipsOldCommands(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	a.inbox.sinceIDs = make(map[string]string)
+	server.directMessage("@me", "@you", "send 1")
+
+	export := `{"commands": {"enabled": true, "allowlist": ["@you"], "lastids": {"@me": 1}}}`
+	if _, err := a.ImportConfig([]byte(export), ImportOptions{Mode: ImportMerge, Settings: true}); err != nil {
+		t.Fatal(err)
+	}
+	a.Poll()
+	if len(server.tweeted("@me")) != 0 || len(server.sentMessages("@me")) != 0 {
+		t.Errorf("a command sent before importing was run")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// FakeClient is an in-memory TwitterClient for tests that stores what is sent instead of sending it
+// Like Twitter, it rejects a tweet or direct message that is the same as the last one with a duplicate status error.
+// Mentions and Received are what it returns as mentions and direct messages received (add them with Receive...).
+// Set Err to make every call fail with that error
+type FakeClient struct {
+	sync.Mutex
+	Username       string
+	Tweets         []anaconda.Tweet
+	DirectMessages []anaconda.DirectMessage
+	Mentions       []anaconda.Tweet
+	Received       []anaconda.DirectMessage
+	Err            error
+	nextID         int64
+}
+
+// NewFakeClient creates a FakeClient for the user with username
+func NewFakeClient(username string) *FakeClient {
+	return &FakeClient{
+		Username: username,
+		nextID:   1,
+	}
+}
+
+// GetSelf returns the fake user
+func (c *FakeClient) GetSelf() (anaconda.User, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return anaconda.User{}, c.Err
+	}
+	return anaconda.User{ScreenName: strings.TrimPrefix(c.Username, "@")}, nil
+}
+
+// PostTweet stores a tweet
+func (c *FakeClient) PostTweet(status string, v url.Values) (anaconda.Tweet, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return anaconda.Tweet{}, c.Err
+	}
+	if len(c.Tweets) > 0 && c.Tweets[len(c.Tweets)-1].Text == status {
+		return anaconda.Tweet{}, duplicateError()
+	}
+	tweet := anaconda.Tweet{
+		Id:    c.nextID,
+		IdStr: fmt.Sprintf("%d", c.nextID),
+		Text:  status,
+		User:  anaconda.User{ScreenName: strings.TrimPrefix(c.Username, "@")},
+	}
+	c.nextID++
+	c.Tweets = append(c.Tweets, tweet)
+	log.Infof("Fake tweet from %s: %s", c.Username, status)
+	return tweet, nil
+}
+
+// DeleteTweet removes a stored tweet
+func (c *FakeClient) DeleteTweet(id int64) (anaconda.Tweet, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return anaconda.Tweet{}, c.Err
+	}
+	for i, tweet := range c.Tweets {
+		if tweet.Id == id {
+			c.Tweets = append(c.Tweets[:i], c.Tweets[i+1:]...)
+			log.Infof("Fake delete from %s: %s", c.Username, tweet.Text)
+			return tweet, nil
+		}
+	}
+	return anaconda.Tweet{}, &anaconda.ApiError{StatusCode: 404}
+}
+
+// PostDM stores a direct message
+func (c *FakeClient) PostDM(text, screenName string) (anaconda.DirectMessage, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return anaconda.DirectMessage{}, c.Err
+	}
+	if len(c.DirectMessages) > 0 && c.DirectMessages[len(c.DirectMessages)-1].Text == text {
+		return anaconda.DirectMessage{}, duplicateError()
+	}
+	message := anaconda.DirectMessage{
+		Id:                  c.nextID,
+		IdStr:               fmt.Sprintf("%d", c.nextID),
+		Text:                text,
+		SenderScreenName:    strings.TrimPrefix(c.Username, "@"),
+		RecipientScreenName: strings.TrimPrefix(screenName, "@"),
+	}
+	c.nextID++
+	c.DirectMessages = append(c.DirectMessages, message)
+	log.Infof("Fake direct message from %s to %s: %s", c.Username, screenName, text)
+	return message, nil
+}
+
+// duplicateError is the error Twitter gives for a status that is the same as the last one
+func duplicateError() error {
+	return &anaconda.ApiError{
+		StatusCode: http.StatusForbidden,
+		Decoded: anaconda.TwitterErrorResponse{
+			Errors: []anaconda.TwitterError{
+				anaconda.TwitterError{
+					Code:    anaconda.TwitterErrorStatusIsADuplicate,
+					Message: "Status is a duplicate.",
+				},
+			},
+		},
+	}
+}
+
+// UploadMedia pretends to upload an image
+func (c *FakeClient) UploadMedia(data string) (anaconda.Media, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return anaconda.Media{}, c.Err
+	}
+	media := anaconda.Media{
+		MediaID:       c.nextID,
+		MediaIDString: fmt.Sprintf("%d", c.nextID),
+	}
+	c.nextID++
+	log.Infof("Fake media upload for %s: %d bytes", c.Username, len(data))
+	return media, nil
+}
+
+// ReceiveMention adds a tweet mentioning the account from user
+func (c *FakeClient) ReceiveMention(user, text string) {
+	c.Lock()
+	defer c.Unlock()
+	c.Mentions = append(c.Mentions, anaconda.Tweet{
+		Id:    c.nextID,
+		IdStr: fmt.Sprintf("%d", c.nextID),
+		Text:  text,
+		User:  anaconda.User{ScreenName: strings.TrimPrefix(user, "@")},
+	})
+	c.nextID++
+}
+
+// ReceiveDirectMessage adds a direct message to the account from user
+func (c *FakeClient) ReceiveDirectMessage(user, text string) {
+	c.Lock()
+	defer c.Unlock()
+	c.Received = append(c.Received, anaconda.DirectMessage{
+		Id:                  c.nextID,
+		IdStr:               fmt.Sprintf("%d", c.nextID),
+		Text:                text,
+		SenderScreenName:    strings.TrimPrefix(user, "@"),
+		RecipientScreenName: strings.TrimPrefix(c.Username, "@"),
+	})
+	c.nextID++
+}
+
+// GetHomeTimeline returns the account's own tweets (newest first)
+func (c *FakeClient) GetHomeTimeline(v url.Values) ([]anaconda.Tweet, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return newestTweets(c.Tweets, v), nil
+}
+
+// GetMentions returns the mentions (newest first)
+func (c *FakeClient) GetMentions(v url.Values) ([]anaconda.Tweet, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return newestTweets(c.Mentions, v), nil
+}
+
+// GetDirectMessages returns the direct messages received (newest first)
+func (c *FakeClient) GetDirectMessages(v url.Values) ([]anaconda.DirectMessage, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	sinceID, count := pageValues(v)
+	var messages []anaconda.DirectMessage
+	for i := len(c.Received) - 1; i >= 0 && len(messages) < count; i-- {
+		if c.Received[i].Id > sinceID {
+			messages = append(messages, c.Received[i])
+		}
+	}
+	return messages, nil
+}
+
+// newestTweets returns the tweets newer than v's since_id, newest first and up to v's count
+func newestTweets(tweets []anaconda.Tweet, v url.Values) []anaconda.Tweet {
+	sinceID, count := pageValues(v)
+	var newest []anaconda.Tweet
+	for i := len(tweets) - 1; i >= 0 && len(newest) < count; i-- {
+		if tweets[i].Id > sinceID {
+			newest = append(newest, tweets[i])
+		}
+	}
+	return newest
+}
+
+// pageValues returns the since_id and count from request values (count defaults to 20 like Twitter)
+func pageValues(v url.Values) (int64, int) {
+	sinceID, _ := strconv.ParseInt(v.Get("since_id"), 10, 64)
+	count, err := strconv.Atoi(v.Get("count"))
+	if err != nil {
+		count = 20
+	}
+	return sinceID, count
+}
+
+func TestFakeClient(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	client := NewFakeClient("@me")
+	a.newClient = func(account AccountDetails) TwitterClient {
+		return client
+	}
+	account, _ := a.Account("@me")
+	if err := a.InitTwitterAPI(account); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.SendStoredTweet("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.Tweets) != 1 || client.Tweets[0].Text != "Hello 1" || len(server.tweeted("@me")) != 0 {
+		t.Errorf("fake tweets are %+v, want the stored tweet", client.Tweets)
+	}
+	client.Err = &anaconda.ApiError{StatusCode: http.StatusServiceUnavailable}
+	if result, _ := a.SendStoredTweet("hello", nil); !result.Queued {
+		t.Errorf("a tweet that failed with %v wasn't queued", client.Err)
+	}
+}
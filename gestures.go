@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ninjasphere/gestic-tools/go-gestic-sdk"
+)
+
+// Gestures (other than tap and double tap) that can be given an action
+const (
+	GestureAirWheel   = "airwheel"
+	GestureFlickUp    = "flickup"
+	GestureFlickDown  = "flickdown"
+	GestureFlickLeft  = "flickleft"
+	GestureFlickRight = "flickright"
+	GestureHover      = "hover"
+)
+
+// Actions the gestures can do
+// ActionScroll is only for the airwheel, which moves through the tweets (or mentions/messages) as you turn it
+const (
+	ActionNone          = "none"
+	ActionScroll        = "scroll"
+	ActionNext          = "next"
+	ActionPrevious      = "previous"
+	ActionSend          = "send"
+	ActionCancel        = "cancel"
+	ActionPreview       = "preview"
+	ActionInbox         = "inbox"
+	ActionNextGroup     = "nextgroup"
+	ActionPreviousGroup = "previousgroup"
+)
+
+// ActionTap and ActionDoubleTap are what taps do, they can't be changed
+const (
+	ActionTap       = "tap"
+	ActionDoubleTap = "doubletap"
+)
+
+// gestureNames is the order the gestures are shown in Labs, with their descriptions
+var gestureNames = []string{GestureAirWheel, GestureFlickUp, GestureFlickDown, GestureFlickLeft, GestureFlickRight, GestureHover}
+
+var gestureTitles = map[string]string{
+	GestureAirWheel:   "Airwheel (circle your finger)",
+	GestureFlickUp:    "Flick up",
+	GestureFlickDown:  "Flick down",
+	GestureFlickLeft:  "Flick left",
+	GestureFlickRight: "Flick right",
+	GestureHover:      "Hold your hand still over it",
+}
+
+// actionNames is the order the actions for the flicks and hover are shown in Labs, with their descriptions
+var actionNames = []string{ActionNone, ActionNext, ActionPrevious, ActionSend, ActionCancel, ActionPreview, ActionInbox, ActionNextGroup, ActionPreviousGroup}
+
+var actionTitles = map[string]string{
+	ActionNone:          "Nothing",
+	ActionScroll:        "Scroll through tweets",
+	ActionNext:          "Next tweet",
+	ActionPrevious:      "Previous tweet",
+	ActionSend:          "Send the tweet",
+	ActionCancel:        "Cancel sending / undo / leave mentions",
+	ActionPreview:       "Scroll the message (without sending)",
+	ActionInbox:         "Show/hide mentions and messages",
+	ActionNextGroup:     "Next group of tweets",
+	ActionPreviousGroup: "Previous group of tweets",
+}
+
+// defaultGestures are the actions for gestures that haven't been set in Labs
+var defaultGestures = map[string]string{
+	GestureAirWheel:   ActionScroll,
+	GestureFlickUp:    ActionSend,
+	GestureFlickDown:  ActionCancel,
+	GestureFlickLeft:  ActionPreviousGroup,
+	GestureFlickRight: ActionNextGroup,
+	GestureHover:      ActionPreview,
+}
+
+// flickGestures maps the gestic sensor's gesture names to ours
+var flickGestures = map[string]string{
+	"SouthToNorth": GestureFlickUp,
+	"NorthToSouth": GestureFlickDown,
+	"EastToWest":   GestureFlickLeft,
+	"WestToEast":   GestureFlickRight,
+}
+
+// airWheelStep is how far the airwheel counter goes for each tweet
+const airWheelStep = 32
+
+// hoverTime is how long a hand has to stay still for a hover
+var hoverTime = time.Millisecond * 1500
+
+// hoverTolerance is how far (in sensor units) a hand can move and still be hovering
+const hoverTolerance = 4000
+
+// checkGestures returns an error if gestures has a gesture that doesn't exist or an action it can't do
+// (the airwheel can only scroll, the others can't). Blank actions are the defaults
+func checkGestures(gestures map[string]string) error {
+	for gesture, action := range gestures {
+		if _, ok := gestureTitles[gesture]; !ok {
+			return fmt.Errorf("There is no gesture called %s", gesture)
+		}
+		if action == "" {
+			continue
+		}
+		_, known := actionTitles[action]
+		if !known || (action != ActionNone && (action == ActionScroll) != (gesture == GestureAirWheel)) {
+			return fmt.Errorf("%s can't be used for %s", action, gestureTitles[gesture])
+		}
+	}
+	return nil
+}
+
+// GestureAction returns the action for gesture, from the config or the default
+func (a *TwitterApp) GestureAction(gesture string) string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	if action, ok := a.config.Gestures[gesture]; ok && action != "" {
+		return action
+	}
+	return defaultGestures[gesture]
+}
+
+// moreGestures handles the airwheel, flicks and hovering, doing the actions set for them
+func (p *LEDPane) moreGestures(gesture *gestic.GestureMessage) {
+	if name, ok := flickGestures[gesture.Gesture.Name()]; ok {
+		log.Infof("Flick! %s", name)
+		p.hoverStart = time.Time{}
+		p.handle(p.app.GestureAction(name))
+		return
+	}
+
+	if gesture.AirWheel.Active {
+		p.hoverStart = time.Time{}
+		if p.app.GestureAction(GestureAirWheel) == ActionScroll {
+			p.airWheel(gesture.AirWheel.Counter)
+		}
+		return
+	}
+	p.airWheelActive = false
+
+	// hovering is a hand near the sensor but not touching it, not moving much
+	position := gesture.Position
+	if gesture.Touch.Active() || (position.X == 0 && position.Y == 0 && position.Z == 0) {
+		p.hoverStart = time.Time{}
+		return
+	}
+	if p.hoverStart.IsZero() || abs(position.X-p.hoverPosition.X) > hoverTolerance ||
+		abs(position.Y-p.hoverPosition.Y) > hoverTolerance || abs(position.Z-p.hoverPosition.Z) > hoverTolerance {
+		p.hoverStart = time.Now()
+		p.hoverPosition = position
+		p.hovered = false
+		return
+	}
+	if !p.hovered && time.Since(p.hoverStart) > hoverTime {
+		log.Infof("Hover!")
+		// only once until the hand moves
+		p.hovered = true
+		p.handle(p.app.GestureAction(GestureHover))
+	}
+}
+
+// airWheel moves through the tweets (or mentions/messages) one at a time as the counter goes around
+func (p *LEDPane) airWheel(counter int) {
+	if !p.airWheelActive {
+		p.airWheelActive = true
+		p.airWheelCounter = counter
+		return
+	}
+	// the counter wraps around at 256, so take the shortest way round (and keep the saved counter in 0-255 to match)
+	change := (counter-p.airWheelCounter+384)%256 - 128
+	for change >= airWheelStep {
+		p.handle(ActionNext)
+		p.airWheelCounter = (p.airWheelCounter + airWheelStep + 256) % 256
+		change -= airWheelStep
+	}
+	for change <= -airWheelStep {
+		p.handle(ActionPrevious)
+		p.airWheelCounter = (p.airWheelCounter - airWheelStep + 256) % 256
+		change += airWheelStep
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
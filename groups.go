@@ -0,0 +1,115 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+	"unicode/utf8"
+)
+
+// groupColour is for the initial of the current group on the LED matrix
+var groupColour = color.RGBA{180, 120, 255, 255}
+
+// hasGroup returns whether there is a group called name
+func (m *TwitterAppModel) hasGroup(name string) bool {
+	return indexOf(m.Groups, name) >= 0
+}
+
+// groupOf returns the group tweet is in, blank if it isn't in one (or its group has been deleted)
+func (m *TwitterAppModel) groupOf(tweet TweetDetails) string {
+	if m.hasGroup(tweet.Group) {
+		return tweet.Group
+	}
+	return ""
+}
+
+// NavigationGroups returns the groups that have tweets, in order, followed by "" if there are tweets not in a group
+// (just "" if there are no groups, so all the tweets are in one list like before)
+func (m *TwitterAppModel) NavigationGroups() []string {
+	used := make(map[string]bool)
+	for _, name := range m.TweetNames {
+		used[m.groupOf(m.Tweets[name])] = true
+	}
+	var groups []string
+	for _, group := range m.Groups {
+		if used[group] {
+			groups = append(groups, group)
+		}
+	}
+	if used[""] || len(groups) == 0 {
+		groups = append(groups, "")
+	}
+	return groups
+}
+
+// GroupTweetNames returns the names of the tweets in group in order ("" for the tweets not in a group)
+func (m *TwitterAppModel) GroupTweetNames(group string) []string {
+	var names []string
+	for _, name := range m.TweetNames {
+		if m.groupOf(m.Tweets[name]) == group {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// renameGroup renames a group (or adds it if previous is blank), moving its tweets to the new name
+func (m *TwitterAppModel) renameGroup(previous, name string) {
+	i := indexOf(m.Groups, previous)
+	if previous == "" || i < 0 {
+		m.Groups = append(m.Groups, name)
+		return
+	}
+	m.Groups[i] = name
+	for tweetName, tweet := range m.Tweets {
+		if tweet.Group == previous {
+			tweet.Group = name
+			m.Tweets[tweetName] = tweet
+		}
+	}
+}
+
+// deleteGroup removes a group, its tweets are kept without a group
+func (m *TwitterAppModel) deleteGroup(name string) {
+	i := indexOf(m.Groups, name)
+	if i < 0 {
+		return
+	}
+	m.Groups = append(m.Groups[:i], m.Groups[i+1:]...)
+	for tweetName, tweet := range m.Tweets {
+		if tweet.Group == name {
+			tweet.Group = ""
+			m.Tweets[tweetName] = tweet
+		}
+	}
+}
+
+// NavigationGroups returns the groups to choose between on the spheramid (see TwitterAppModel.NavigationGroups)
+func (a *TwitterApp) NavigationGroups() []string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.NavigationGroups()
+}
+
+// GroupTweetNames returns the names of the tweets in group in order ("" for the tweets not in a group)
+func (a *TwitterApp) GroupTweetNames(group string) []string {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.GroupTweetNames(group)
+}
+
+// groupTitle returns how group is shown, tweets without a group are in "Other"
+func groupTitle(group string) string {
+	if group == "" {
+		return "Other"
+	}
+	return group
+}
+
+// groupInitial returns the letter shown on the LED matrix for group
+func groupInitial(group string) string {
+	initial, _ := utf8.DecodeRuneInString(group)
+	if initial == utf8.RuneError {
+		return ""
+	}
+	return strings.ToUpper(string(initial))
+}
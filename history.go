@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lindsaymarkward/go-ninja/config"
+)
+
+// historyFile is where the sent history is kept (it's not in the config because it changes with every send)
+var historyFile = config.String("twitter-history.json", "twitter.history.file")
+
+// historySize is the number of sends kept in the history, the oldest are overwritten after that
+const historySize = 500
+
+// historyShown is the number of recent sends shown on the History screen
+const historyShown = 50
+
+// Export formats for the history
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json"
+)
+
+// HistoryEntry records one tweet or direct message that was sent (or failed)
+// Tweet is the name of the stored tweet, blank for messages sent through the service or as command replies
+// Attempts is more than 1 for messages that were queued and retried
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	Tweet    string    `json:"tweet"`
+	Account  string    `json:"account"`
+	To       string    `json:"to"`
+	Message  string    `json:"message"`
+	Success  bool      `json:"success"`
+	Queued   bool      `json:"queued"`
+	ID       string    `json:"id"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+}
+
+// historyRing is the ring buffer saved in the history file, Next is where the next entry goes
+type historyRing struct {
+	Next    int            `json:"next"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// sentHistory holds the lock for the history, which is loaded from the file the first time it's used
+type sentHistory struct {
+	sync.Mutex
+	loaded bool
+	ring   historyRing
+}
+
+// add puts entry in the ring, overwriting the oldest one when it's full
+func (r *historyRing) add(entry HistoryEntry) {
+	if len(r.Entries) < historySize {
+		r.Entries = append(r.Entries, entry)
+		r.Next = len(r.Entries) % historySize
+		return
+	}
+	r.Entries[r.Next] = entry
+	r.Next = (r.Next + 1) % historySize
+}
+
+// newestFirst returns a copy of the entries with the most recent first
+func (r *historyRing) newestFirst() []HistoryEntry {
+	n := len(r.Entries)
+	entries := make([]HistoryEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, r.Entries[(r.Next-i+n)%n])
+	}
+	return entries
+}
+
+// load reads the history file the first time it's needed (history lock must be held)
+// A missing or unreadable file starts a new history
+func (h *sentHistory) load() {
+	if h.loaded {
+		return
+	}
+	h.loaded = true
+	data, err := ioutil.ReadFile(historyFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Could not read history file %s: %v", historyFile, err)
+		}
+		return
+	}
+	var saved historyRing
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Errorf("Could not load history file %s, starting a new one: %v", historyFile, err)
+		return
+	}
+	if saved.Next < 0 || saved.Next > len(saved.Entries) {
+		// the oldest entry isn't known, so they're taken to be in the order they were added
+		saved.Next = len(saved.Entries)
+	}
+	// add them again from the oldest, keeping the newest if it was saved when more were kept
+	entries := saved.newestFirst()
+	if len(entries) > historySize {
+		entries = entries[:historySize]
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		h.ring.add(entries[i])
+	}
+}
+
+// save writes the history file, replacing it only when the new one has been written (history lock must be held)
+func (h *sentHistory) save() {
+	data, err := json.Marshal(h.ring)
+	if err != nil {
+		log.Errorf("Could not save history: %v", err)
+		return
+	}
+	temp := historyFile + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		log.Errorf("Could not write history file %s: %v", temp, err)
+		return
+	}
+	if err := os.Rename(temp, historyFile); err != nil {
+		log.Errorf("Could not replace history file %s: %v", historyFile, err)
+	}
+}
+
+// recordSent adds the result of sending (or trying to send) the stored tweet called tweet to the history
+func (a *TwitterApp) recordSent(tweet string, attempts int, result *SendResult) {
+	if result == nil {
+		return
+	}
+	a.history.Lock()
+	defer a.history.Unlock()
+	a.history.load()
+	a.history.ring.add(HistoryEntry{
+		Time:     result.Time,
+		Tweet:    tweet,
+		Account:  result.Account,
+		To:       result.To,
+		Message:  result.Message,
+		Success:  result.Success,
+		Queued:   result.Queued,
+		ID:       result.ID,
+		Attempts: attempts,
+		Error:    result.Error,
+	})
+	a.history.save()
+}
+
+// History returns the sent history, most recent first
+func (a *TwitterApp) History() []HistoryEntry {
+	a.history.Lock()
+	defer a.history.Unlock()
+	a.history.load()
+	return a.history.ring.newestFirst()
+}
+
+// ExportHistory returns the sent history (most recent first) as CSV or JSON (ExportCSV or ExportJSON)
+func (a *TwitterApp) ExportHistory(format string) (string, error) {
+	entries := a.History()
+	switch format {
+	case ExportJSON:
+		data, err := json.MarshalIndent(entries, "", "  ")
+		return string(data), err
+	case ExportCSV:
+		var buffer bytes.Buffer
+		w := csv.NewWriter(&buffer)
+		w.Write([]string{"time", "tweet", "account", "to", "message", "success", "queued", "id", "attempts", "error"})
+		for _, entry := range entries {
+			w.Write([]string{
+				entry.Time.Format(time.RFC3339),
+				entry.Tweet,
+				entry.Account,
+				entry.To,
+				entry.Message,
+				strconv.FormatBool(entry.Success),
+				strconv.FormatBool(entry.Queued),
+				entry.ID,
+				strconv.Itoa(entry.Attempts),
+				entry.Error,
+			})
+		}
+		w.Flush()
+		return buffer.String(), w.Error()
+	}
+	return "", fmt.Errorf("Unknown export format %q, use %s or %s", format, ExportCSV, ExportJSON)
+}
+
+// exportFile returns the file that what (e.g. "history") is exported to in format
+func exportFile(what, format string) string {
+	return "twitter-" + what + "-export." + format
+}
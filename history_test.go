@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyMessages returns the messages of entries
+func historyMessages(entries []HistoryEntry) []string {
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	return messages
+}
+
+// numberedMessages returns the numbers from first down to last as strings
+func numberedMessages(first, last int) []string {
+	var messages []string
+	for i := first; i >= last; i-- {
+		messages = append(messages, strconv.Itoa(i))
+	}
+	return messages
+}
+
+func TestHistoryRingWraps(t *testing.T) {
+	var ring historyRing
+	for i := 0; i < historySize+10; i++ {
+		ring.add(HistoryEntry{Message: strconv.Itoa(i)})
+	}
+	if len(ring.Entries) != historySize || ring.Next != 10 {
+		t.Errorf("ring has %d entries and next is %d, want %d and 10", len(ring.Entries), ring.Next, historySize)
+	}
+	if got := historyMessages(ring.newestFirst()); !reflect.DeepEqual(got, numberedMessages(historySize+9, 10)) {
+		t.Errorf("newest first after wrapping is %v...", got[:5])
+	}
+}
+
+// loadHistory writes ring to a new history file and loads it
+func loadHistory(t *testing.T, ring historyRing) *sentHistory {
+	historyFile = filepath.Join(t.TempDir(), "twitter-history.json")
+	data, err := json.Marshal(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(historyFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := &sentHistory{}
+	h.load()
+	return h
+}
+
+func TestHistoryLoad(t *testing.T) {
+	// saved when 600 were kept, wrapped so the oldest is at 100
+	var saved historyRing
+	for i := 0; i < 600; i++ {
+		saved.Entries = append(saved.Entries, HistoryEntry{Message: strconv.Itoa((i + 500) % 600)})
+	}
+	saved.Next = 100
+	h := loadHistory(t, saved)
+	if got := historyMessages(h.ring.newestFirst()); !reflect.DeepEqual(got, numberedMessages(599, 100)) {
+		t.Errorf("loading a bigger history kept %d entries from %v, want the newest %d", len(got), got[:1], historySize)
+	}
+	h.ring.add(HistoryEntry{Message: "600"})
+	if got := h.ring.newestFirst(); len(got) != historySize || got[0].Message != "600" || got[historySize-1].Message != "101" {
+		t.Errorf("adding after loading a bigger history didn't replace the oldest")
+	}
+
+	// a bad Next takes them in the order they were added
+	for _, next := range []int{-1, 4, 100} {
+		h := loadHistory(t, historyRing{Next: next, Entries: []HistoryEntry{{Message: "0"}, {Message: "1"}, {Message: "2"}}})
+		h.ring.add(HistoryEntry{Message: "3"})
+		if got := historyMessages(h.ring.newestFirst()); !reflect.DeepEqual(got, numberedMessages(3, 0)) {
+			t.Errorf("history with next %d is %v after adding, want the new one first then the saved ones in reverse", next, got)
+		}
+	}
+	full := historyRing{Next: historySize}
+	for i := 0; i < historySize; i++ {
+		full.Entries = append(full.Entries, HistoryEntry{Message: strconv.Itoa(i)})
+	}
+	h = loadHistory(t, full)
+	h.ring.add(HistoryEntry{Message: strconv.Itoa(historySize)})
+	if got := historyMessages(h.ring.newestFirst()); !reflect.DeepEqual(got, numberedMessages(historySize, 1)) {
+		t.Errorf("adding to a full history saved with next %d gave %v...", historySize, got[:3])
+	}
+
+	// an unreadable file starts a new history
+	historyFile = filepath.Join(t.TempDir(), "twitter-history.json")
+	ioutil.WriteFile(historyFile, []byte("{not json"), 0600)
+	h = &sentHistory{}
+	h.load()
+	if len(h.ring.Entries) != 0 {
+		t.Errorf("an unreadable history file loaded %d entries", len(h.ring.Entries))
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	historyFile = filepath.Join(t.TempDir(), "twitter-history.json")
+	a := &TwitterApp{}
+	start := time.Date(2015, 5, 15, 10, 0, 0, 0, time.UTC)
+	messages := []string{"Hello, world", "Line 1\nLine 2", `She said "hi"`}
+	for i, message := range messages {
+		a.recordSent("hello", 1, &SendResult{Success: true, Account: "@me", Message: message, Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	a.recordSent("", 2, &SendResult{Account: "@me", To: "@you", Message: "Failed", Error: "Twitter said no, again", Time: start.Add(time.Hour)})
+
+	exported, err := a.ExportHistory(ExportCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(exported)).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV export can't be read: %v\n%s", err, exported)
+	}
+	if len(records) != 5 || records[0][0] != "time" {
+		t.Fatalf("CSV export has %d records, want the header and 4", len(records))
+	}
+	if want := []string{"2015-05-15T11:00:00Z", "", "@me", "@you", "Failed", "false", "false", "", "2", "Twitter said no, again"}; !reflect.DeepEqual(records[1], want) {
+		t.Errorf("newest CSV record is %q, want %q", records[1], want)
+	}
+	for i, message := range messages {
+		if got := records[len(records)-1-i][4]; got != message {
+			t.Errorf("CSV message is %q, want %q", got, message)
+		}
+	}
+
+	exported, err = a.ExportHistory(ExportJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal([]byte(exported), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if got := historyMessages(entries); !reflect.DeepEqual(got, []string{"Failed", messages[2], messages[1], messages[0]}) {
+		t.Errorf("JSON export is %q, want newest first", got)
+	}
+	if !entries[0].Time.Equal(start.Add(time.Hour)) || entries[0].Attempts != 2 {
+		t.Errorf("newest JSON entry is %+v", entries[0])
+	}
+
+	if _, err := a.ExportHistory("xml"); err == nil {
+		t.Errorf("exporting as xml worked")
+	}
+}
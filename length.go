@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Twitter's limits - tweets are counted with weights (see WeightedLength), direct messages by characters
+const (
+	maxTweetLength         = 280
+	maxDirectMessageLength = 10000
+)
+
+// transformedURLLength is what every URL counts as, because Twitter shortens them all to t.co links
+const transformedURLLength = 23
+
+// weights are in hundredths of a character, most characters count as 2 apart from the weightRanges
+// (Latin, Greek, Cyrillic and some punctuation, which count as 1)
+const (
+	weightScale   = 100
+	defaultWeight = 200
+)
+
+var weightRanges = []struct {
+	start, end rune
+	weight     int
+}{
+	{0, 4351, 100},
+	{8192, 8205, 100},
+	{8208, 8223, 100},
+	{8242, 8247, 100},
+}
+
+// urlPattern finds the URLs in a message (starting with http://, https:// or www.), leaving out punctuation
+// at the end like Twitter does (e.g. the full stop after a URL at the end of a sentence)
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]*[^\s.,;:!?)]`)
+
+// exampleEventValue is used for {{.Value}} and {{.Temperature}} when measuring triggered tweets
+const exampleEventValue = "00.0"
+
+// longestFuncs are the template functions for measuring messages, which make choose return its longest choice
+var longestFuncs = template.FuncMap{
+	"choose": func(choices ...string) string {
+		longest := ""
+		for _, choice := range choices {
+			if WeightedLength(choice) > WeightedLength(longest) {
+				longest = choice
+			}
+		}
+		return longest
+	},
+}
+
+// WeightedLength counts text like Twitter does for tweets (twitter-text version 3): after NFC normalisation,
+// each URL counts as 23, each emoji (including modifiers and joined sequences) as 2, CJK and most other
+// characters as 2 and the rest (in weightRanges) as 1
+func WeightedLength(text string) int {
+	text = norm.NFC.String(text)
+	urls := urlPattern.FindAllStringIndex(text, -1)
+	weight := 0
+	for i := 0; i < len(text); {
+		if len(urls) > 0 && i == urls[0][0] {
+			weight += transformedURLLength * weightScale
+			i = urls[0][1]
+			urls = urls[1:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		if isEmoji(r) {
+			weight += defaultWeight
+			i += emojiTail(text[i:], r)
+			continue
+		}
+		weight += runeWeight(r)
+	}
+	return weight / weightScale
+}
+
+// runeWeight returns how much r counts for
+func runeWeight(r rune) int {
+	for _, weightRange := range weightRanges {
+		if r >= weightRange.start && r <= weightRange.end {
+			return weightRange.weight
+		}
+	}
+	return defaultWeight
+}
+
+// isEmoji checks whether r starts an emoji (pictographs, symbols, dingbats and flags)
+func isEmoji(r rune) bool {
+	return (r >= 0x1F000 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF) || (r >= 0x2B00 && r <= 0x2BFF)
+}
+
+// isRegionalIndicator checks whether r is one of the letters that make flags in pairs
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// emojiTail returns the number of bytes at the start of rest that are part of the emoji starting with first
+// (variation selectors, skin tones, keycaps, tags and other emoji joined with a zero-width joiner)
+func emojiTail(rest string, first rune) int {
+	if isRegionalIndicator(first) {
+		if r, size := utf8.DecodeRuneInString(rest); isRegionalIndicator(r) {
+			return size
+		}
+		return 0
+	}
+	n := 0
+	for n < len(rest) {
+		r, size := utf8.DecodeRuneInString(rest[n:])
+		switch {
+		case r == 0xFE0E || r == 0xFE0F || r == 0x20E3 || (r >= 0x1F3FB && r <= 0x1F3FF) || (r >= 0xE0020 && r <= 0xE007F):
+			n += size
+		case r == 0x200D:
+			next, nextSize := utf8.DecodeRuneInString(rest[n+size:])
+			if !isEmoji(next) {
+				return n
+			}
+			n += size + nextSize
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// directMessageLength counts text like Twitter does for direct messages (characters after NFC normalisation)
+func directMessageLength(text string) int {
+	return utf8.RuneCountInString(norm.NFC.String(text))
+}
+
+// MessageLength returns the length of the longest message tweet can send and the limit for it (tweet or DM)
+// Each of its messages is rendered with the longest choices (and example event values if it's triggered)
+// and with the suffix it would have if it was retried as a duplicate, with the counter a few sends ahead
+func MessageLength(tweet TweetDetails) (length, limit int, err error) {
+	limit, count := maxTweetLength, WeightedLength
+	if tweet.To != "" {
+		limit, count = maxDirectMessageLength, directMessageLength
+	}
+
+	next := tweet
+	next.Number++
+	next.Variation += 1 + maxVariationRetries
+	messages := 1
+	if next.uniqueness() == UniqueAlternatives {
+		messages += len(tweet.Alternatives)
+	}
+	data := NewTemplateData(next, time.Now(), nil)
+	if tweet.Trigger != nil {
+		data.Value, data.Temperature = exampleEventValue, exampleEventValue
+	}
+	for i := 0; i < messages; i++ {
+		if next.uniqueness() == UniqueAlternatives {
+			next.Variation = i
+		}
+		message, err := renderMessage(next, data, longestFuncs, true)
+		if err != nil {
+			return 0, limit, err
+		}
+		if n := count(message); n > length {
+			length = n
+		}
+	}
+	return length, limit, nil
+}
+
+// checkLength returns an error if the longest message tweet can send is too long (or its template is invalid)
+func checkLength(tweet TweetDetails) error {
+	length, limit, err := MessageLength(tweet)
+	if err != nil {
+		return err
+	}
+	return lengthError(tweet.To, length, limit, "could be")
+}
+
+// checkMessageLength returns an error if message is too long to send as a tweet, or as a direct message if to is set
+func checkMessageLength(message, to string) error {
+	if to == "" {
+		return lengthError(to, WeightedLength(message), maxTweetLength, "is")
+	}
+	return lengthError(to, directMessageLength(message), maxDirectMessageLength, "is")
+}
+
+// lengthError returns an error saying how long a tweet (or direct message if to is set) is if it's over limit
+func lengthError(to string, length, limit int, verb string) error {
+	if length <= limit {
+		return nil
+	}
+	kind := "tweet"
+	if to != "" {
+		kind = "direct message"
+	}
+	return fmt.Errorf("the %s %s %d characters long, the limit is %d", kind, verb, length, limit)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWeightedLength(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want int
+	}{
+		{"Hello", 5},
+		{"Привет, κόσμε", 13},
+		// CJK counts as 2
+		{"日本語", 6},
+		{"日本語 text", 11},
+		{"한국어", 6},
+		// emoji count as 2, including modifiers, joined sequences and flags
+		{"😀", 2},
+		{"❤️", 2},
+		{"👍🏽", 2},
+		{"👨‍👩‍👧", 2},
+		{"👩‍💻 coding", 9},
+		{"🇦🇺", 2},
+		{"🇦🇺🇳🇿", 4},
+		{"Hi 😀😀", 7},
+		// NFD is normalised to NFC first
+		{"h\u00e9llo", 5},
+		{"he\u0301llo", 5},
+		// URLs count as 23, without the punctuation after them
+		{"https://example.com/a/very/long/path/that/goes/on/and/on", 23},
+		{"see http://t.co/x", 27},
+		{"www.example.com", 23},
+		{"Look: https://example.com.", 30},
+		{"https://example.com, and", 28},
+		{"(www.example.com)", 25},
+		{"https://example.com/?q=1!?", 25},
+		{"https://a.com https://b.com", 47},
+	} {
+		if got := WeightedLength(test.text); got != test.want {
+			t.Errorf("WeightedLength(%q) is %d, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestMessageLength(t *testing.T) {
+	for _, test := range []struct {
+		tweet               TweetDetails
+		wantLength, wantMax int
+	}{
+		// the counter a few sends ahead is added to the end
+		{TweetDetails{Message: "Hello"}, 7, maxTweetLength},
+		{TweetDetails{Message: "Hello", Variation: 6}, 8, maxTweetLength},
+		{TweetDetails{Message: "Hello", Unique: UniqueTimestamp}, len("Hello 15:04:05.000"), maxTweetLength},
+		{TweetDetails{Message: "Hello", Unique: UniqueNone}, 5, maxTweetLength},
+		{TweetDetails{Message: "日本語", Unique: UniqueNone}, 6, maxTweetLength},
+		// the longest alternative and the longest choice
+		{TweetDetails{Message: "Hi", Alternatives: []string{"Hello there", "Hey"}, Unique: UniqueAlternatives}, 11, maxTweetLength},
+		{TweetDetails{Message: `{{choose "a" "日本"}}`, Unique: UniqueNone}, 4, maxTweetLength},
+		// direct messages count characters and have their own limit
+		{TweetDetails{Message: "日本語", To: "@you", Unique: UniqueNone}, 3, maxDirectMessageLength},
+		{TweetDetails{Message: "Hello", To: "@you"}, 7, maxDirectMessageLength},
+	} {
+		length, max, err := MessageLength(test.tweet)
+		if err != nil || length != test.wantLength || max != test.wantMax {
+			t.Errorf("MessageLength(%q) is %d of %d, %v, want %d of %d", test.tweet.Message, length, max, err, test.wantLength, test.wantMax)
+		}
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	// 279 characters fit, but not with the counter
+	tweet := TweetDetails{Name: "long", Message: strings.Repeat("a", 279)}
+	if err := checkLength(tweet); err == nil {
+		t.Errorf("a tweet that's too long with its counter was allowed")
+	}
+	tweet.Unique = UniqueNone
+	if err := checkLength(tweet); err != nil {
+		t.Errorf("a tweet that fits returned %v", err)
+	}
+	// CJK counts double for tweets but not for direct messages
+	cjk := strings.Repeat("日", 141)
+	if err := checkMessageLength(cjk, ""); err == nil {
+		t.Errorf("a tweet of %d CJK characters was allowed", 141)
+	}
+	if err := checkMessageLength(cjk, "@you"); err != nil {
+		t.Errorf("a direct message of %d CJK characters returned %v", 141, err)
+	}
+	if err := checkMessageLength(strings.Repeat("a", maxDirectMessageLength+1), "@you"); err == nil {
+		t.Errorf("a direct message over %d characters was allowed", maxDirectMessageLength)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lindsaymarkward/go-ninja/config"
+)
+
+// MediaSnapshot as a stored tweet's media attaches a picture of what the LED matrix is showing
+const MediaSnapshot = "snapshot"
+
+// maxMediaSize is the largest image Twitter accepts
+const maxMediaSize = 5 * 1024 * 1024
+
+// snapshotScale is how much the 16x16 LED matrix is enlarged for snapshots
+const snapshotScale = 16
+
+// snapshotPrefix starts the names of snapshot files, which are deleted once they've been sent
+const snapshotPrefix = "app-twitter-snapshot-"
+
+// snapshotDir is where snapshots are saved until they've been sent (not the system's temp directory,
+// so snapshots for queued messages are still there after a restart). Only snapshots in it are ever deleted
+var snapshotDir = config.String("twitter-snapshots", "twitter.snapshot.dir")
+
+// mediaDir is the directory that other apps can attach local images from through the service (blank for none,
+// so they can only attach URLs). Stored tweets can use any file
+var mediaDir = config.String("", "twitter.media.dir")
+
+// mediaClient downloads images from URLs
+var mediaClient = &http.Client{Timeout: time.Second * 30}
+
+// MediaError is an image that can't be attached (e.g. the file doesn't exist), so sending it again won't work
+type MediaError struct {
+	Source string
+	Err    error
+}
+
+func (e *MediaError) Error() string {
+	return fmt.Sprintf("Could not attach %s: %v", e.Source, e.Err)
+}
+
+// loadMedia reads the image from source, which is a file path or an http(s) URL
+func loadMedia(source string) ([]byte, error) {
+	var data []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		response, err := mediaClient.Get(source)
+		if err != nil {
+			// probably the network, so worth trying again
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, &MediaError{source, fmt.Errorf("download failed: %s", response.Status)}
+		}
+		// one byte more than the limit is enough to know it's too big without downloading all of it
+		data, err = ioutil.ReadAll(io.LimitReader(response.Body, maxMediaSize+1))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		data, err = ioutil.ReadFile(source)
+		if err != nil {
+			return nil, &MediaError{source, err}
+		}
+	}
+	if len(data) > maxMediaSize {
+		return nil, &MediaError{source, fmt.Errorf("image is bigger than %d bytes", maxMediaSize)}
+	}
+	return data, nil
+}
+
+// uploadMedia uploads the image from source with client, returning the media ID to attach to a tweet
+func uploadMedia(client TwitterClient, source string) (string, error) {
+	data, err := loadMedia(source)
+	if err != nil {
+		return "", err
+	}
+	media, err := client.UploadMedia(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return "", err
+	}
+	return media.MediaIDString, nil
+}
+
+// Snapshot saves what the LED matrix is showing, enlarged, to a PNG file and returns its path
+func (a *TwitterApp) Snapshot() (string, error) {
+	if a.pane == nil {
+		return "", &MediaError{MediaSnapshot, fmt.Errorf("there is no LED pane")}
+	}
+	frame, err := a.pane.SnapshotFrame()
+	if err != nil {
+		return "", &MediaError{MediaSnapshot, err}
+	}
+
+	// enlarge each LED to a square
+	bounds := frame.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*snapshotScale, bounds.Dy()*snapshotScale))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			led := image.Rect(x*snapshotScale, y*snapshotScale, (x+1)*snapshotScale, (y+1)*snapshotScale)
+			draw.Draw(img, led, &image.Uniform{frame.At(x, y)}, image.Point{0, 0}, draw.Src)
+		}
+	}
+
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		return "", &MediaError{MediaSnapshot, err}
+	}
+	path := filepath.Join(snapshotDir, fmt.Sprintf("%s%d.png", snapshotPrefix, time.Now().UnixNano()))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", &MediaError{MediaSnapshot, err}
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		return "", &MediaError{MediaSnapshot, err}
+	}
+	return path, nil
+}
+
+// isSnapshot returns whether media is a snapshot file that the app saved (in snapshotDir)
+func isSnapshot(media string) bool {
+	if !strings.HasPrefix(filepath.Base(media), snapshotPrefix) || filepath.Ext(media) != ".png" {
+		return false
+	}
+	dir, err := filepath.Abs(filepath.Dir(media))
+	if err != nil {
+		return false
+	}
+	own, err := filepath.Abs(snapshotDir)
+	return err == nil && dir == own
+}
+
+// removeSnapshot deletes media if it's a snapshot file (once it has been sent or given up on)
+func removeSnapshot(media string) {
+	if isSnapshot(media) {
+		os.Remove(media)
+	}
+}
+
+// removeOldSnapshots deletes the snapshot files that aren't for any of the pending messages
+// (e.g. when the app stopped while sending)
+func removeOldSnapshots(pending []QueuedMessage) {
+	files, err := ioutil.ReadDir(snapshotDir)
+	if err != nil {
+		return
+	}
+	queued := make(map[string]bool)
+	for _, item := range pending {
+		queued[filepath.Base(item.Media)] = true
+	}
+	for _, file := range files {
+		if !queued[file.Name()] {
+			removeSnapshot(filepath.Join(snapshotDir, file.Name()))
+		}
+	}
+}
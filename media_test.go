@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMediaLimitsDownloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := 10
+		if r.URL.Path == "/big.png" {
+			size = maxMediaSize + 10
+		}
+		w.Write(bytes.Repeat([]byte{1}, size))
+	}))
+	defer server.Close()
+
+	if data, err := loadMedia(server.URL + "/small.png"); err != nil || len(data) != 10 {
+		t.Errorf("loading a small image returned %d bytes, %v", len(data), err)
+	}
+	if _, err := loadMedia(server.URL + "/big.png"); err == nil {
+		t.Errorf("loading an image bigger than %d bytes worked", maxMediaSize)
+	} else if _, ok := err.(*MediaError); !ok {
+		t.Errorf("loading an image that's too big returned %v, want a MediaError", err)
+	}
+}
+
+func TestRemoveSnapshotOnlyRemovesOwn(t *testing.T) {
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), snapshotPrefix+"1.png")
+	own := filepath.Join(snapshotDir, snapshotPrefix+"1.png")
+	queued := filepath.Join(snapshotDir, snapshotPrefix+"2.png")
+	notSnapshot := filepath.Join(snapshotDir, "photo.png")
+	for _, path := range []string{other, own, queued, notSnapshot} {
+		if err := ioutil.WriteFile(path, []byte("image"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Remove(queued)
+	defer os.Remove(notSnapshot)
+
+	removeSnapshot(other)
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("a file outside the snapshot directory was deleted")
+	}
+	removeOldSnapshots([]QueuedMessage{{Media: queued}})
+	if _, err := os.Stat(own); !os.IsNotExist(err) {
+		t.Errorf("a snapshot that isn't queued wasn't deleted")
+	}
+	for _, path := range []string{queued, notSnapshot} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was deleted", filepath.Base(path))
+		}
+	}
+}
+
+func TestSnapshotWhileSending(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	p := newTestPane(a)
+	choosing, _ := p.Render()
+
+	p.Lock()
+	p.startTweeting()
+	p.Unlock()
+	frame, err := p.SnapshotFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Pix, choosing.Pix) {
+		t.Errorf("snapshot while sending isn't what was showing before sending")
+	}
+
+	path, err := a.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSnapshot(path) {
+		t.Errorf("snapshot was saved to %s, want %s", path, snapshotDir)
+	}
+	removeSnapshot(path)
+}
+
+func TestRetrySendsWithoutMissingSnapshot(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	missing := filepath.Join(snapshotDir, snapshotPrefix+"missing.png")
+	a.Enqueue(TweetDetails{}, "@me", "", "Hello", missing, fmt.Errorf("network is down"))
+
+	a.RetryQueue(true)
+	if tweets := server.tweeted("@me"); len(tweets) != 1 || a.PendingCount() != 0 {
+		t.Errorf("tweets are %q with %d pending, want the queued one sent without its snapshot", tweets, a.PendingCount())
+	}
+}
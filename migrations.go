@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// migrations upgrade configs saved by older versions of the app, in order. Version 0 is a config from before
+// there were versions, and the last migration's version is the current one (configVersion)
+// Each one runs once, when the app starts with a config that has an older version. Ones that fail are tried
+// again the next time. stored ones are only for the app's own config, not imported exports
+var migrations = []struct {
+	version     int
+	description string
+	stored      bool
+	migrate     func(m *TwitterAppModel) error
+}{
+	{1, "move the single account into the accounts", false, func(m *TwitterAppModel) error { m.moveSingleAccount(); return nil }},
+	{2, "give the stored tweets IDs", false, func(m *TwitterAppModel) error { m.assignTweetIDs(); return nil }},
+	{3, "separate the variation counters from the send counts", false, func(m *TwitterAppModel) error { m.splitTweetCounters(); return nil }},
+	// exported secrets are encrypted with a passphrase instead
+	{4, "encrypt the account secrets", true, (*TwitterAppModel).encryptSecrets},
+	{5, "give the pending messages their stored tweets' IDs", true, func(m *TwitterAppModel) error { m.assignPendingTweetIDs(); return nil }},
+}
+
+// configVersion is the version of configs saved by this version of the app
+var configVersion = migrations[len(migrations)-1].version
+
+// migrate upgrades m from the version it was saved with to configVersion, returns whether anything changed
+// (it stops at a migration that fails, so it's tried again next time). imported is true for an imported export,
+// which skips the stored migrations. A config from a newer version of the app is left as it is
+func (m *TwitterAppModel) migrate(imported bool) bool {
+	if m.isNewer() {
+		log.Errorf("The config is version %d but this version of the app only knows up to %d, it won't be upgraded", m.Version, configVersion)
+		return false
+	}
+	from := m.Version
+	for _, migration := range migrations {
+		if migration.version <= m.Version {
+			continue
+		}
+		if !imported || !migration.stored {
+			log.Infof("Upgrading config to version %d: %s", migration.version, migration.description)
+			if err := migration.migrate(m); err != nil {
+				log.Errorf("Could not upgrade config to version %d: %v", migration.version, err)
+				break
+			}
+		}
+		m.Version = migration.version
+	}
+	return m.Version != from
+}
+
+// isNewer returns whether m was saved by a newer version of the app
+func (m *TwitterAppModel) isNewer() bool {
+	return m.Version > configVersion
+}
+
+// moveSingleAccount moves the single account from older configs into the accounts map (and makes it the default)
+func (m *TwitterAppModel) moveSingleAccount() {
+	if m.Account == nil {
+		return
+	}
+	if m.Account.Username != "" {
+		m.Accounts[m.Account.Username] = *m.Account
+		m.DefaultAccount = m.Account.Username
+	}
+	m.Account = nil
+}
+
+// encryptSecrets encrypts the secrets of accounts saved before they were encrypted
+// Accounts that can't be encrypted are left as they are (and the error returned)
+func (m *TwitterAppModel) encryptSecrets() error {
+	var failed error
+	for username, account := range m.Accounts {
+		if !account.hasPlaintextSecrets() {
+			continue
+		}
+		encrypted, err := account.Encrypted()
+		if err != nil {
+			failed = fmt.Errorf("could not encrypt the secrets for %s: %v", username, err)
+			continue
+		}
+		log.Infof("Encrypted the secrets for %s", username)
+		m.Accounts[username] = encrypted
+	}
+	return failed
+}
+
+// assignPendingTweetIDs gives the pending messages queued before they kept their stored tweet's ID
+// the ID of the tweet with their name (if it's still there)
+func (m *TwitterAppModel) assignPendingTweetIDs() {
+	for i, item := range m.Pending {
+		if tweet, ok := m.Tweets[item.Tweet]; ok && item.TweetID == "" {
+			m.Pending[i].TweetID = tweet.ID
+		}
+	}
+}
+
+// makeMaps makes the maps in m that are missing (e.g. in a new config)
+func (m *TwitterAppModel) makeMaps() {
+	if m.Accounts == nil {
+		m.Accounts = make(map[string]AccountDetails)
+	}
+	if m.Tweets == nil {
+		m.Tweets = make(map[string]TweetDetails)
+	}
+}
+
+// repair fixes things in m that don't match up, e.g. the Tweets map and the TweetNames order after a failed edit,
+// and returns a description of each fix
+func (m *TwitterAppModel) repair() []string {
+	var fixes []string
+	m.makeMaps()
+
+	// every name in the order has one tweet
+	var names []string
+	listed := make(map[string]bool)
+	for _, name := range m.TweetNames {
+		if _, ok := m.Tweets[name]; !ok {
+			fixes = append(fixes, fmt.Sprintf("removed %q from the order, there is no tweet with that name", name))
+			continue
+		}
+		if listed[name] {
+			fixes = append(fixes, fmt.Sprintf("removed %q from the order again, it was there more than once", name))
+			continue
+		}
+		listed[name] = true
+		names = append(names, name)
+	}
+	// and every tweet is in the order (missing ones go at the end, alphabetically)
+	var missing []string
+	for name := range m.Tweets {
+		if !listed[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fixes = append(fixes, fmt.Sprintf("added %q to the end of the order, it was missing", name))
+		names = append(names, name)
+	}
+	m.TweetNames = names
+
+	// each tweet has its own name and a different ID
+	ids := make(map[string]bool)
+	for _, name := range m.TweetNames {
+		tweet := m.Tweets[name]
+		if tweet.Name != name {
+			fixes = append(fixes, fmt.Sprintf("renamed the tweet stored as %q from %q", name, tweet.Name))
+			tweet.Name = name
+		}
+		if tweet.ID != "" && ids[tweet.ID] {
+			fixes = append(fixes, fmt.Sprintf("gave %q a new ID, %s was used by another tweet", name, tweet.ID))
+			tweet.ID = m.newTweetID()
+		}
+		ids[tweet.ID] = true
+		m.Tweets[name] = tweet
+	}
+
+	// groups have names and are only listed once
+	var groups []string
+	for _, group := range m.Groups {
+		if group == "" || indexOf(groups, group) >= 0 {
+			fixes = append(fixes, fmt.Sprintf("removed the group %q, it was blank or there more than once", group))
+			continue
+		}
+		groups = append(groups, group)
+	}
+	m.Groups = groups
+
+	if _, ok := m.Accounts[m.DefaultAccount]; !ok && len(m.Accounts) > 0 {
+		replacement := m.AccountNames()[0]
+		fixes = append(fixes, fmt.Sprintf("made %s the default account, %q doesn't exist", replacement, m.DefaultAccount))
+		m.DefaultAccount = replacement
+	}
+	return fixes
+}
+
+// deleteTweet removes the stored tweet called name from the map and the order, returns false if there isn't one
+func (m *TwitterAppModel) deleteTweet(name string) bool {
+	_, ok := m.Tweets[name]
+	delete(m.Tweets, name)
+	if i := indexOf(m.TweetNames, name); i >= 0 {
+		m.TweetNames = append(m.TweetNames[:i], m.TweetNames[i+1:]...)
+		ok = true
+	}
+	return ok
+}
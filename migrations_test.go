@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMigrateEncryptsSecrets(t *testing.T) {
+	account := AccountDetails{Username: "@me", ConsumerKey: "key", ConsumerSecret: "secret",
+		AccessToken: "token", AccessTokenSecret: "token secret"}
+	m := &TwitterAppModel{Version: 3, Accounts: map[string]AccountDetails{"@me": account}}
+	if !m.migrate(false) || m.Version != configVersion {
+		t.Fatalf("config is version %d after upgrading, want %d", m.Version, configVersion)
+	}
+	if m.Accounts["@me"].hasPlaintextSecrets() {
+		t.Errorf("secrets weren't encrypted")
+	}
+	if decrypted, err := m.Accounts["@me"].Decrypted(); err != nil || decrypted != account {
+		t.Errorf("decrypted account is %+v, %v", decrypted, err)
+	}
+
+	// an import's secrets are left for the passphrase to decrypt
+	exported := account
+	exported.ConsumerSecret = passphrasePrefix + "salt:secret"
+	imported := &TwitterAppModel{Version: 3, Accounts: map[string]AccountDetails{"@me": exported}}
+	imported.migrate(true)
+	if imported.Version != configVersion || imported.Accounts["@me"] != exported {
+		t.Errorf("imported account is %+v after upgrading, want it unchanged", imported.Accounts["@me"])
+	}
+}
+
+func TestMigrateLeavesNewerConfigs(t *testing.T) {
+	m := &TwitterAppModel{Version: configVersion + 1, Accounts: map[string]AccountDetails{
+		"@me": {Username: "@me", ConsumerSecret: "secret"},
+	}}
+	if m.migrate(false) || m.Version != configVersion+1 || m.Accounts["@me"].ConsumerSecret != "secret" {
+		t.Errorf("a config from a newer version was changed to %+v", m)
+	}
+}
+
+func TestMigrateGivesPendingTweetIDs(t *testing.T) {
+	m := &TwitterAppModel{
+		Version:    4,
+		TweetNames: []string{"hello"},
+		Tweets:     map[string]TweetDetails{"hello": {Name: "hello", ID: "1", Message: "Hello"}},
+		Pending:    []QueuedMessage{{ID: "a", Tweet: "hello"}, {ID: "b", Tweet: "deleted"}, {ID: "c"}},
+	}
+	m.migrate(false)
+	if m.Pending[0].TweetID != "1" || m.Pending[1].TweetID != "" || m.Pending[2].TweetID != "" {
+		t.Errorf("pending messages are %+v, want the first with its tweet's ID", m.Pending)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/garyburd/go-oauth/oauth"
+	"github.com/lindsaymarkward/go-ninja/config"
+)
+
+// twitterOAuthURL is where Twitter's OAuth endpoints are
+const twitterOAuthURL = "https://api.twitter.com"
+
+// default consumer key and secret for signing in with Twitter, so users only need the PIN
+var defaultConsumerKey = config.String("", "twitter.consumer.key")
+var defaultConsumerSecret = config.String("", "twitter.consumer.secret")
+
+// signInTimeout is how long a sign in can wait for its PIN
+var signInTimeout = time.Minute * 15
+
+// signIn is a "Sign in with Twitter" that is waiting for the user to enter the PIN
+// authURL is where the user gets the PIN, for showing it again when the PIN is wrong
+type signIn struct {
+	client      *oauth.Client
+	credentials *oauth.Credentials
+	authURL     string
+	started     time.Time
+}
+
+// signIns stores the sign ins waiting for PINs, keyed by temporary token
+type signIns struct {
+	sync.Mutex
+	pending map[string]signIn
+}
+
+// newOAuthClient creates an OAuth client for the Twitter app with consumerKey and consumerSecret
+// that uses the OAuth endpoints at a.oauthURL if it's set, or Twitter's
+func (a *TwitterApp) newOAuthClient(consumerKey, consumerSecret string) *oauth.Client {
+	oauthURL := a.oauthURL
+	if oauthURL == "" {
+		oauthURL = twitterOAuthURL
+	}
+	return &oauth.Client{
+		TemporaryCredentialRequestURI: oauthURL + "/oauth/request_token",
+		ResourceOwnerAuthorizationURI: oauthURL + "/oauth/authorize",
+		TokenRequestURI:               oauthURL + "/oauth/access_token",
+		Credentials: oauth.Credentials{
+			Token:  consumerKey,
+			Secret: consumerSecret,
+		},
+	}
+}
+
+// StartSignIn starts the PIN based (out-of-band) OAuth flow, returning the URL where the user authorises the app
+// and gets the PIN, and the temporary token to finish the sign in with
+func (a *TwitterApp) StartSignIn(consumerKey, consumerSecret string) (string, string, error) {
+	if consumerKey == "" || consumerSecret == "" {
+		return "", "", fmt.Errorf("A consumer key and secret are needed to sign in")
+	}
+	client := a.newOAuthClient(consumerKey, consumerSecret)
+	credentials, err := client.RequestTemporaryCredentials(http.DefaultClient, "oob", nil)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not start signing in with Twitter: %v", err)
+	}
+
+	a.signIns.Lock()
+	defer a.signIns.Unlock()
+	if a.signIns.pending == nil {
+		a.signIns.pending = make(map[string]signIn)
+	}
+	// forget old sign ins that were never finished
+	for token, s := range a.signIns.pending {
+		if time.Since(s.started) > signInTimeout {
+			delete(a.signIns.pending, token)
+		}
+	}
+	authURL := client.AuthorizationURL(credentials, nil)
+	a.signIns.pending[credentials.Token] = signIn{client, credentials, authURL, time.Now()}
+
+	return authURL, credentials.Token, nil
+}
+
+// FinishSignIn exchanges the PIN for the sign in with token for access tokens, and returns the account details
+// The sign in is only finished when that works, so a wrong PIN can be entered again (until signInTimeout)
+func (a *TwitterApp) FinishSignIn(token, pin string) (AccountDetails, error) {
+	s, ok := a.pendingSignIn(token)
+	if !ok {
+		return AccountDetails{}, fmt.Errorf("Sign in has expired, please start again")
+	}
+
+	credentials, values, err := s.client.RequestToken(http.DefaultClient, s.credentials, pin)
+	if err != nil {
+		return AccountDetails{}, fmt.Errorf("Could not finish signing in with Twitter (check the PIN): %v", err)
+	}
+	a.signIns.Lock()
+	delete(a.signIns.pending, token)
+	a.signIns.Unlock()
+	return AccountDetails{
+		Username:          addAt(values.Get("screen_name")),
+		ConsumerKey:       s.client.Credentials.Token,
+		ConsumerSecret:    s.client.Credentials.Secret,
+		AccessToken:       credentials.Token,
+		AccessTokenSecret: credentials.Secret,
+	}, nil
+}
+
+// SignInURL returns the link for getting the PIN for the sign in with token, if it hasn't expired
+func (a *TwitterApp) SignInURL(token string) (string, bool) {
+	s, ok := a.pendingSignIn(token)
+	return s.authURL, ok
+}
+
+// pendingSignIn returns the sign in with token if it hasn't expired (expired ones are forgotten)
+func (a *TwitterApp) pendingSignIn(token string) (signIn, bool) {
+	a.signIns.Lock()
+	defer a.signIns.Unlock()
+	s, ok := a.signIns.pending[token]
+	if ok && time.Since(s.started) > signInTimeout {
+		delete(a.signIns.pending, token)
+		return s, false
+	}
+	return s, ok
+}
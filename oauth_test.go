@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-ninja/suit"
+)
+
+// oauthServer is a stand-in for Twitter's OAuth endpoints, where the PIN is "1234"
+// and signing in gives the tokens for username on the stand-in Twitter API
+type oauthServer struct {
+	*httptest.Server
+	sync.Mutex
+	username  string
+	exchanges int
+}
+
+// newOAuthServer starts an oauthServer for username and points a's sign ins at it
+func newOAuthServer(t *testing.T, a *TwitterApp, username string) *oauthServer {
+	s := &oauthServer{username: username}
+	s.Server = httptest.NewServer(s)
+	a.oauthURL = s.URL
+	t.Cleanup(s.Close)
+	return s
+}
+
+// oauthParam returns the value of the oauth parameter name from the request's Authorization header
+func oauthParam(request *http.Request, name string) string {
+	for _, param := range strings.Split(strings.TrimPrefix(request.Header.Get("Authorization"), "OAuth "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(parts) == 2 && parts[0] == name {
+			return strings.Trim(parts[1], `"`)
+		}
+	}
+	return request.FormValue(name)
+}
+
+func (s *oauthServer) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	switch request.URL.Path {
+	case "/oauth/request_token":
+		w.Write([]byte("oauth_token=temporary&oauth_token_secret=secret&oauth_callback_confirmed=true"))
+	case "/oauth/access_token":
+		s.Lock()
+		s.exchanges++
+		s.Unlock()
+		if oauthParam(request, "oauth_token") != "temporary" || oauthParam(request, "oauth_verifier") != "1234" {
+			http.Error(w, "Invalid request token", http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(s.username, "@")
+		w.Write([]byte("oauth_token=token-" + name + "&oauth_token_secret=secret&screen_name=" + name))
+	default:
+		http.NotFound(w, request)
+	}
+}
+
+func (s *oauthServer) exchangeCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.exchanges
+}
+
+func TestSignIn(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	newOAuthServer(t, a, "@me")
+
+	_, token, err := a.StartSignIn("key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.FinishSignIn(token, "4321"); err == nil {
+		t.Fatalf("signing in with the wrong PIN worked")
+	}
+	// the PIN can be entered again
+	account, err := a.FinishSignIn(token, "1234")
+	if err != nil {
+		t.Fatalf("signing in after a wrong PIN returned %v", err)
+	}
+	if account.Username != "@me" || account.AccessToken != "token-me" || account.ConsumerKey != "key" {
+		t.Errorf("signed in account is %+v", account)
+	}
+	if _, err := a.FinishSignIn(token, "1234"); err == nil {
+		t.Errorf("signing in again with a finished sign in worked")
+	}
+}
+
+func TestSignInExpires(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	oauth := newOAuthServer(t, a, "@me")
+
+	_, token, err := a.StartSignIn("key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.signIns.Lock()
+	s := a.signIns.pending[token]
+	s.started = s.started.Add(-signInTimeout - time.Minute)
+	a.signIns.pending[token] = s
+	a.signIns.Unlock()
+
+	if _, err := a.FinishSignIn(token, "1234"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("finishing an expired sign in returned %v", err)
+	}
+	if oauth.exchangeCount() != 0 {
+		t.Errorf("the PIN for an expired sign in was sent to Twitter")
+	}
+}
+
+func TestConfigureSignIn(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	c := &ConfigService{a}
+	newOAuthServer(t, a, "@you")
+	server.addUser("@you")
+
+	screen := configure(t, c, "signIn", nil)
+	for _, content := range screen.Sections[0].Contents {
+		if input, ok := content.(suit.InputText); ok && input.Name == "consumersecret" && input.InputType != "password" {
+			t.Errorf("consumer secret is shown as %q, want a password", input.InputType)
+		}
+	}
+
+	_, token, err := a.StartSignIn("key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a wrong PIN shows the PIN form again
+	screen = configure(t, c, "finishSignIn", map[string]string{"token": token, "pin": "4321"})
+	if alert := screenAlert(screen); !strings.Contains(alert, "check the PIN") || screen.Actions[1].(suit.ReplyAction).Name != "finishSignIn" {
+		t.Errorf("a wrong PIN showed %q", alert)
+	}
+	configure(t, c, "finishSignIn", map[string]string{"token": token, "pin": " 1234 "})
+	if !a.IsInitialised("@you") {
+		t.Errorf("accounts are %q after signing in to @you", a.AccountNames())
+	}
+}
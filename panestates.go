@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"github.com/ninjasphere/sphere-go-led-controller/fonts/O4b03b"
+)
+
+// paneState is one screen of the LED pane
+// Enter and Exit are called when the pane changes to and from it, Render draws it, HandleGesture does what an
+// action (from a gesture) means in it and Tick is called regularly (e.g. for timeouts).
+// They're all called with the pane's lock held. To add a screen, add a PaneState constant, an implementation
+// in paneStates and its transitions in paneTransitions.
+type paneState interface {
+	Enter(p *LEDPane)
+	Exit(p *LEDPane)
+	Render(p *LEDPane, img *image.RGBA)
+	HandleGesture(p *LEDPane, action string)
+	Tick(p *LEDPane)
+}
+
+// paneStates has the implementation of each state
+var paneStates = map[PaneState]paneState{
+	ErrorAccount:   errorAccountState{},
+	Choosing:       choosingState{},
+	Inbox:          inboxState{},
+	Confirming:     confirmingState{},
+	Previewing:     previewingState{},
+	Tweeting:       tweetingState{},
+	Undoing:        undoingState{},
+	TweetSucceeded: resultState{renderSucceeded},
+	TweetFailed:    resultState{renderFailed},
+	TweetPending:   resultState{renderPending},
+	RateLimited:    resultState{renderRateLimited},
+	Deleted:        resultState{renderDeleted},
+}
+
+// paneTransitions are the states each state can change to
+var paneTransitions = map[PaneState][]PaneState{
+	ErrorAccount:   {Choosing},
+	Choosing:       {ErrorAccount, Inbox, Confirming, Previewing, Tweeting},
+	Inbox:          {ErrorAccount, Choosing},
+	Confirming:     {Choosing, Previewing, Tweeting},
+	Previewing:     {Choosing, Tweeting},
+	Tweeting:       {TweetSucceeded, TweetFailed, TweetPending, RateLimited, Undoing, Deleted},
+	Undoing:        {Choosing, Tweeting},
+	TweetSucceeded: {Choosing},
+	TweetFailed:    {Choosing},
+	TweetPending:   {Choosing},
+	RateLimited:    {Choosing},
+	Deleted:        {Choosing},
+}
+
+// resultTime is how long the result of sending (or deleting) is shown
+var resultTime = time.Second * 2
+
+// canChange returns whether the pane can change from one state to another
+func canChange(from, to PaneState) bool {
+	for _, state := range paneTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// drawBird draws the Twitter bird with the current tweet number in colour
+func drawBird(p *LEDPane, img *image.RGBA, colour color.RGBA) {
+	draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), colour)
+}
+
+// errorAccountState shows that no account is working
+type errorAccountState struct{}
+
+func (errorAccountState) Enter(p *LEDPane) {}
+func (errorAccountState) Exit(p *LEDPane)  {}
+
+func (errorAccountState) Render(p *LEDPane, img *image.RGBA) {
+	// @ with animated cross through it
+	draw.Draw(img, img.Bounds(), images["at"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	draw.Draw(img, img.Bounds(), images["error"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+}
+
+func (errorAccountState) HandleGesture(p *LEDPane, action string) {}
+
+func (errorAccountState) Tick(p *LEDPane) {
+	if p.app.IsReady() {
+		p.setState(Choosing)
+	}
+}
+
+// choosingState shows the current stored tweet, tap left/right to change it and double tap to send it
+type choosingState struct{}
+
+func (choosingState) Enter(p *LEDPane) {
+	p.scrollName()
+}
+
+func (choosingState) Exit(p *LEDPane) {}
+
+func (choosingState) Render(p *LEDPane, img *image.RGBA) {
+	// different tweet numbers and DM or TWT text
+	draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	if !p.hasStoredTweets {
+		O4b03b.Font.DrawString(img, 4, 5, "NO", color.RGBA{255, 0, 0, 255})
+	} else {
+		// display tweet number (and the initial of its group) and type on Spheramid
+		O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 250, 0, 255})
+		if p.group != "" {
+			O4b03b.Font.DrawString(img, 1, 3, groupInitial(p.group), groupColour)
+		}
+		if p.scroller != nil && !p.scroller.Finished() {
+			// tweet name scrolling in place of the type
+			p.scroller.Draw(img)
+		} else if tweet, _ := p.app.StoredTweet(p.currentName()); tweet.To == "" {
+			O4b03b.Font.DrawString(img, 2, 10, "TWT", color.RGBA{20, 255, 20, 255})
+		} else {
+			O4b03b.Font.DrawString(img, 3, 10, "DM", color.RGBA{20, 255, 250, 255})
+		}
+	}
+	// red corner shows that there are unread mentions/messages
+	if len(p.app.Unread()) > 0 {
+		draw.Draw(img, image.Rect(0, 0, 2, 2), &image.Uniform{unreadColour}, image.Point{0, 0}, draw.Src)
+	}
+}
+
+func (choosingState) HandleGesture(p *LEDPane, action string) {
+	switch action {
+	case ActionTap:
+		if p.hasStoredTweets || p.tapAction == ActionInbox {
+			p.startTapTimer()
+		}
+	case ActionDoubleTap, ActionSend:
+		p.send()
+	case ActionNext:
+		p.changeTweet(1)
+	case ActionPrevious:
+		p.changeTweet(-1)
+	case ActionNextGroup:
+		p.changeGroup(1)
+	case ActionPreviousGroup:
+		p.changeGroup(-1)
+	case ActionPreview:
+		p.showMessage()
+	case ActionInbox:
+		if len(p.app.Unread()) > 0 {
+			p.setState(Inbox)
+		}
+	}
+}
+
+func (choosingState) Tick(p *LEDPane) {
+	if !p.app.IsReady() {
+		p.setState(ErrorAccount)
+	}
+}
+
+// inboxState scrolls the unread mentions/messages, tap left/right to change and double tap to mark one read
+type inboxState struct{}
+
+func (inboxState) Enter(p *LEDPane) {
+	p.showNotification(0)
+}
+
+func (inboxState) Exit(p *LEDPane) {}
+
+func (inboxState) Render(p *LEDPane, img *image.RGBA) {
+	// bird with unread count and the mention/message scrolling (again and again)
+	draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", len(p.app.Unread())), unreadColour)
+	if p.scroller != nil {
+		if p.scroller.Finished() {
+			p.scroller.Restart()
+		}
+		p.scroller.Draw(img)
+	}
+}
+
+func (inboxState) HandleGesture(p *LEDPane, action string) {
+	switch action {
+	case ActionTap:
+		p.startTapTimer()
+	case ActionDoubleTap:
+		// mark the mention/message read and show the next one
+		p.app.MarkRead(p.notification.ID)
+		p.showNotification(0)
+	case ActionNext:
+		p.showNotification(1)
+	case ActionPrevious:
+		p.showNotification(-1)
+	case ActionCancel, ActionInbox:
+		p.setState(Choosing)
+	}
+}
+
+func (inboxState) Tick(p *LEDPane) {
+	// stay showing mentions/messages until they've all been read
+	if !p.app.IsReady() {
+		p.setState(ErrorAccount)
+	} else if len(p.app.Unread()) == 0 {
+		p.setState(Choosing)
+	}
+}
+
+// confirmingState flashes the tweet number until it's tapped to confirm sending (or it times out)
+type confirmingState struct{}
+
+func (confirmingState) Enter(p *LEDPane) {
+	p.scroller = nil
+	p.confirmUntil = time.Now().Add(p.app.confirmTime())
+}
+
+func (confirmingState) Exit(p *LEDPane) {}
+
+func (confirmingState) Render(p *LEDPane, img *image.RGBA) {
+	// bird with flashing tweet number and "OK" - tap to confirm sending
+	draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	if time.Now().UnixNano()/int64(flashInterval)%2 == 0 {
+		O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 250, 0, 255})
+	}
+	O4b03b.Font.DrawString(img, 4, 10, "OK", color.RGBA{20, 255, 20, 255})
+}
+
+func (confirmingState) HandleGesture(p *LEDPane, action string) {
+	switch action {
+	case ActionTap, ActionSend:
+		p.sendNow()
+	case ActionCancel:
+		log.Infof("Cancelled sending")
+		p.setState(Choosing)
+	}
+}
+
+func (confirmingState) Tick(p *LEDPane) {
+	if time.Now().After(p.confirmUntil) {
+		log.Infof("Not confirmed, cancelled sending")
+		p.setState(Choosing)
+	}
+}
+
+// previewingState scrolls the message then sends it, tap while it's scrolling to cancel
+type previewingState struct{}
+
+func (previewingState) Enter(p *LEDPane) {
+	message, err := p.app.PreviewMessage(p.currentName())
+	if err != nil {
+		message = err.Error()
+	}
+	speed, colour := p.app.scrollSettings()
+	p.scroller = NewTextScroller(message, colour, speed, 6)
+}
+
+func (previewingState) Exit(p *LEDPane) {}
+
+func (previewingState) Render(p *LEDPane, img *image.RGBA) {
+	// message scrolling before it's sent, with the tweet number above it
+	O4b03b.Font.DrawString(img, 6, 0, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{20, 154, 233, 255})
+	p.scroller.Draw(img)
+}
+
+func (previewingState) HandleGesture(p *LEDPane, action string) {
+	switch action {
+	case ActionTap, ActionCancel:
+		log.Infof("Cancelled sending")
+		p.setState(Choosing)
+	case ActionSend:
+		// send straight away instead of waiting for the scrolling to finish
+		go p.tweetIt(p.startTweeting())
+	}
+}
+
+func (previewingState) Tick(p *LEDPane) {
+	if p.scroller.Finished() {
+		go p.tweetIt(p.startTweeting())
+	}
+}
+
+// tweetingState animates while sending (or deleting)
+type tweetingState struct{}
+
+func (tweetingState) Enter(p *LEDPane) {
+	p.scroller = nil
+}
+
+func (tweetingState) Exit(p *LEDPane) {}
+
+func (tweetingState) Render(p *LEDPane, img *image.RGBA) {
+	draw.Draw(img, img.Bounds(), images["animated"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{20, 154, 233, 255})
+}
+
+func (tweetingState) HandleGesture(p *LEDPane, action string) {}
+func (tweetingState) Tick(p *LEDPane)                         {}
+
+// undoingState shows that the tweet was sent, tap before the bar runs out to delete it
+type undoingState struct{}
+
+func (undoingState) Enter(p *LEDPane) {
+	p.undoUntil = time.Now().Add(p.app.undoTime())
+}
+
+func (undoingState) Exit(p *LEDPane) {}
+
+func (undoingState) Render(p *LEDPane, img *image.RGBA) {
+	// bird with animated tick and tweet number, and a bar along the bottom for the time left to undo
+	renderSucceeded(p, img)
+	if undoTime := p.app.undoTime(); undoTime > 0 && time.Now().Before(p.undoUntil) {
+		width := int(16 * p.undoUntil.Sub(time.Now()) / undoTime)
+		draw.Draw(img, image.Rect(0, 15, width, 16), &image.Uniform{pendingColour}, image.Point{0, 0}, draw.Src)
+	}
+}
+
+func (undoingState) HandleGesture(p *LEDPane, action string) {
+	if action == ActionTap || action == ActionCancel {
+		p.undo()
+	}
+}
+
+func (undoingState) Tick(p *LEDPane) {
+	if time.Now().After(p.undoUntil) {
+		p.sent = nil
+		p.setState(Choosing)
+	}
+}
+
+// resultState shows the result of sending (or deleting) for a while then goes back to choosing
+type resultState struct {
+	render func(p *LEDPane, img *image.RGBA)
+}
+
+func (resultState) Enter(p *LEDPane) {}
+func (resultState) Exit(p *LEDPane)  {}
+
+func (s resultState) Render(p *LEDPane, img *image.RGBA) {
+	s.render(p, img)
+}
+
+func (resultState) HandleGesture(p *LEDPane, action string) {}
+
+func (resultState) Tick(p *LEDPane) {
+	if time.Since(p.entered) > resultTime {
+		p.setState(Choosing)
+	}
+}
+
+func renderSucceeded(p *LEDPane, img *image.RGBA) {
+	// bird with animated tick and tweet number
+	draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	draw.Draw(img, img.Bounds(), images["tick"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 255, 255, 255})
+}
+
+func renderFailed(p *LEDPane, img *image.RGBA) {
+	// bird with animated cross through it and tweet number
+	draw.Draw(img, img.Bounds(), images["logo"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	draw.Draw(img, img.Bounds(), images["error"].GetNextFrame(), image.Point{0, 0}, draw.Over)
+	O4b03b.Font.DrawString(img, 6, 3, fmt.Sprintf("%d", p.currentTweetNumber+1), color.RGBA{255, 255, 255, 255})
+}
+
+func renderPending(p *LEDPane, img *image.RGBA) {
+	// bird with tweet number and orange dots - it failed but will be retried
+	drawBird(p, img, color.RGBA{255, 255, 255, 255})
+	O4b03b.Font.DrawString(img, 4, 10, "...", pendingColour)
+}
+
+func renderRateLimited(p *LEDPane, img *image.RGBA) {
+	// bird with tweet number and magenta "LIM" - the rate limit was reached so it will be sent when it resets
+	drawBird(p, img, color.RGBA{255, 255, 255, 255})
+	O4b03b.Font.DrawString(img, 2, 10, "LIM", color.RGBA{255, 0, 200, 255})
+}
+
+func renderDeleted(p *LEDPane, img *image.RGBA) {
+	// bird with tweet number and red "DEL" - it was sent then deleted
+	drawBird(p, img, color.RGBA{255, 255, 255, 255})
+	O4b03b.Font.DrawString(img, 2, 10, "DEL", color.RGBA{255, 0, 0, 255})
+}
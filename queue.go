@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// queueInterval is how often the queue checks for messages that are due to be retried
+var queueInterval = time.Second * 5
+
+// retry backoff starts at queueBackoff and doubles with each attempt up to queueMaxBackoff
+// messages that still haven't been sent after queueMaxAge are dropped
+var queueBackoff = time.Second * 15
+var queueMaxBackoff = time.Minute * 30
+var queueMaxAge = time.Hour * 24
+
+// QueuedMessage is a tweet or direct message that failed with a temporary error and will be retried
+// Tweet is the name of the stored tweet it was sent for when it was queued and TweetID is its ID (blank if it wasn't
+// a stored tweet). The stored tweet is found by its ID when it's sent, in case it has been renamed meanwhile
+// The pending messages are saved in the config so they are still sent after a restart
+type QueuedMessage struct {
+	ID        string    `json:"id"`
+	Tweet     string    `json:"tweet,omitempty"`
+	TweetID   string    `json:"tweetid,omitempty"`
+	Account   string    `json:"account"`
+	To        string    `json:"to"`
+	Message   string    `json:"message"`
+	Media     string    `json:"media,omitempty"`
+	Queued    time.Time `json:"queued"`
+	NextTry   time.Time `json:"nexttry"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lasterror"`
+}
+
+// sendQueue holds the lock for changing config.Pending (so only one enqueue, delete or retry update happens at once)
+// and the channel for stopping the retry goroutine
+// count is the number of pending messages, which can be read without waiting for the lock
+// retrying has the IDs of the messages being retried, which are sent without the lock held
+type sendQueue struct {
+	sync.Mutex
+	stop     chan bool
+	count    int32
+	retrying map[string]bool
+}
+
+// StartQueue starts the goroutine that retries pending messages (if it's not already running)
+func (a *TwitterApp) StartQueue() {
+	a.queue.Lock()
+	defer a.queue.Unlock()
+	if a.queue.stop != nil {
+		return
+	}
+	a.queue.stop = make(chan bool)
+	pending := a.PendingMessages()
+	atomic.StoreInt32(&a.queue.count, int32(len(pending)))
+	removeOldSnapshots(pending)
+
+	go func(stop chan bool) {
+		ticker := time.NewTicker(queueInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.RetryQueue(false)
+			case <-stop:
+				return
+			}
+		}
+	}(a.queue.stop)
+}
+
+// StopQueue stops the retry goroutine
+func (a *TwitterApp) StopQueue() {
+	a.queue.Lock()
+	defer a.queue.Unlock()
+	if a.queue.stop != nil {
+		close(a.queue.stop)
+		a.queue.stop = nil
+	}
+}
+
+// Enqueue adds a message that failed with err to the pending messages, to be retried after a backoff
+// tweet is the stored tweet it was sent for (empty if it wasn't)
+func (a *TwitterApp) Enqueue(tweet TweetDetails, account, to, message, media string, err error) {
+	a.queue.Lock()
+	defer a.queue.Unlock()
+
+	now := time.Now()
+	item := QueuedMessage{
+		ID:        fmt.Sprintf("%d", now.UnixNano()),
+		Tweet:     tweet.Name,
+		TweetID:   tweet.ID,
+		Account:   account,
+		To:        to,
+		Message:   message,
+		Media:     media,
+		Queued:    now,
+		Attempts:  1,
+		LastError: err.Error(),
+	}
+	item.NextTry = nextTry(item.Attempts, err, now)
+	log.Infof("Queued message from %s to retry at %v: %v", account, item.NextTry, err)
+	a.savePending(append(a.PendingMessages(), item))
+}
+
+// RetryQueue tries to send the pending messages that are due (or all of them if now is true)
+// Sent messages and ones that fail permanently or are too old are removed.
+// They're sent without the queue locked, so messages can be queued and deleted meanwhile
+func (a *TwitterApp) RetryQueue(now bool) {
+	due := a.startRetries(now)
+	if len(due) == 0 {
+		return
+	}
+	retried := make(map[string]*QueuedMessage, len(due))
+	for _, item := range due {
+		retried[item.ID] = a.retry(item)
+	}
+	a.finishRetries(retried)
+}
+
+// startRetries returns the pending messages that are due to be retried (or all of them if now is true)
+// and marks them as being retried, skipping ones that are already being retried
+func (a *TwitterApp) startRetries(now bool) []QueuedMessage {
+	a.queue.Lock()
+	defer a.queue.Unlock()
+	if a.queue.retrying == nil {
+		a.queue.retrying = make(map[string]bool)
+	}
+	var due []QueuedMessage
+	for _, item := range a.PendingMessages() {
+		if a.queue.retrying[item.ID] || (!now && time.Now().Before(item.NextTry)) {
+			continue
+		}
+		a.queue.retrying[item.ID] = true
+		due = append(due, item)
+	}
+	return due
+}
+
+// retry sends a queued message again, returning it updated to stay queued or nil if it's finished with
+// (sent, failed permanently or too old)
+func (a *TwitterApp) retry(item QueuedMessage) *QueuedMessage {
+	if _, ok := a.Account(item.Account); !ok {
+		log.Infof("Dropping queued message %s, account %s no longer exists", item.ID, item.Account)
+		removeSnapshot(item.Media)
+		a.recordRetry(item, "", fmt.Errorf("account %s no longer exists", item.Account))
+		return nil
+	}
+
+	// the snapshot is kept until the message is finished with, but if it was deleted anyway the message is still worth sending without it
+	if isSnapshot(item.Media) {
+		if _, err := os.Stat(item.Media); os.IsNotExist(err) {
+			log.Infof("The snapshot for queued message %s is gone, sending it without it", item.ID)
+			item.Media = ""
+		}
+	}
+
+	id, err := a.post(item.Account, item.To, item.Message, item.Media)
+	item.Attempts++
+	if err == nil {
+		log.Infof("Sent queued message %s after %d attempts", item.ID, item.Attempts)
+		removeSnapshot(item.Media)
+		a.recordRetry(item, id, nil)
+		return nil
+	}
+	item.LastError = err.Error()
+	if !IsTransient(err) {
+		log.Errorf("Dropping queued message %s, it failed permanently: %v", item.ID, err)
+		removeSnapshot(item.Media)
+		a.recordRetry(item, "", err)
+		return nil
+	}
+	if time.Since(item.Queued) > queueMaxAge {
+		log.Errorf("Dropping queued message %s, it couldn't be sent within %v: %v", item.ID, queueMaxAge, err)
+		removeSnapshot(item.Media)
+		a.recordRetry(item, "", err)
+		return nil
+	}
+	item.NextTry = nextTry(item.Attempts, err, time.Now())
+	return &item
+}
+
+// finishRetries puts the results of retrying into the pending messages - the ones still queued are updated in place
+// and the finished ones are removed. Messages deleted while they were being retried stay deleted
+func (a *TwitterApp) finishRetries(retried map[string]*QueuedMessage) {
+	a.queue.Lock()
+	defer a.queue.Unlock()
+	var pending []QueuedMessage
+	for _, item := range a.PendingMessages() {
+		result, ok := retried[item.ID]
+		if !ok {
+			pending = append(pending, item)
+		} else if result != nil {
+			pending = append(pending, *result)
+		}
+	}
+	for id := range retried {
+		delete(a.queue.retrying, id)
+	}
+	a.savePending(pending)
+}
+
+// recordRetry adds the final result of retrying a queued message to the sent history (err is nil if it was sent)
+func (a *TwitterApp) recordRetry(item QueuedMessage, id string, err error) {
+	result := &SendResult{
+		Success: err == nil,
+		ID:      id,
+		Account: item.Account,
+		To:      item.To,
+		Message: item.Message,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	name := item.Tweet
+	if current := a.storedTweetName(item.TweetID); current != "" {
+		name = current
+	}
+	a.recordSent(name, item.Attempts, result)
+	if item.TweetID != "" && result.Success {
+		a.finishStoredTweet(item.TweetID, 0, result)
+	}
+}
+
+// DeleteQueued removes the pending message with id
+func (a *TwitterApp) DeleteQueued(id string) {
+	a.queue.Lock()
+	defer a.queue.Unlock()
+	pending := a.PendingMessages()
+	for i, item := range pending {
+		if item.ID == id {
+			removeSnapshot(item.Media)
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	a.savePending(pending)
+}
+
+// savePending replaces the pending messages, updates the pending count and saves the config (queue lock must be held)
+func (a *TwitterApp) savePending(pending []QueuedMessage) {
+	a.updateConfig(func(m *TwitterAppModel) error {
+		m.Pending = pending
+		return nil
+	})
+	atomic.StoreInt32(&a.queue.count, int32(len(pending)))
+}
+
+// PendingCount returns the number of messages waiting to be retried
+func (a *TwitterApp) PendingCount() int {
+	return int(atomic.LoadInt32(&a.queue.count))
+}
+
+// PendingMessages returns a copy of the pending messages
+func (a *TwitterApp) PendingMessages() []QueuedMessage {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return append([]QueuedMessage(nil), a.config.Pending...)
+}
+
+// IsTransient returns whether err is worth retrying - network errors and timeouts, Twitter server errors and rate limits
+// Errors like duplicate statuses, bad authentication, accounts that aren't set up and unreadable images
+// won't work next time either
+func IsTransient(err error) bool {
+	if err == errClientClosed {
+		// the account's client was replaced while it was being used, the new one can be used next time
+		return true
+	}
+	switch e := err.(type) {
+	case *RateLimitError:
+		return true
+	case net.Error:
+		// no response from Twitter
+		return true
+	case *anaconda.ApiError:
+		if e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests {
+			return true
+		}
+		for _, twitterErr := range e.Decoded.Errors {
+			if twitterErr.Code == anaconda.TwitterErrorRateLimitExceeded {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextTry returns when to retry a message after attempts failed attempts, the last with err
+// Rate limited messages are retried when the rate limit resets
+func nextTry(attempts int, err error, now time.Time) time.Time {
+	if rateErr, ok := err.(*RateLimitError); ok && rateErr.Reset.After(now) {
+		return rateErr.Reset
+	}
+	if apiErr, ok := err.(*anaconda.ApiError); ok {
+		if isRateLimit, reset := apiErr.RateLimitCheck(); isRateLimit && reset.After(now) {
+			return reset
+		}
+	}
+	backoff := queueBackoff
+	for i := 1; i < attempts && backoff < queueMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > queueMaxBackoff {
+		backoff = queueMaxBackoff
+	}
+	return now.Add(backoff)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// Twitter API endpoints that the app calls, which it tracks rate limits for
+const (
+	EndpointTweet          = "statuses/update"
+	EndpointDirectMessage  = "direct_messages/new"
+	EndpointDelete         = "statuses/destroy/:id"
+	EndpointMediaUpload    = "media/upload"
+	EndpointMentions       = "statuses/mentions_timeline"
+	EndpointHomeTimeline   = "statuses/home_timeline"
+	EndpointDirectMessages = "direct_messages"
+	EndpointVerify         = "account/verify_credentials"
+)
+
+// defaultRateLimits are estimates from Twitter's documented limits, used until a response has x-rate-limit headers
+// (Twitter sends them with the timelines, direct messages and account checks but usually not with posts,
+// so until then each request is counted against the estimate)
+var defaultRateLimits = map[string]struct {
+	limit  int
+	window time.Duration
+}{
+	EndpointTweet:          {300, time.Hour * 3},
+	EndpointDirectMessage:  {1000, time.Hour * 24},
+	EndpointDelete:         {300, time.Hour * 3},
+	EndpointMediaUpload:    {415, time.Minute * 15},
+	EndpointMentions:       {75, time.Minute * 15},
+	EndpointHomeTimeline:   {15, time.Minute * 15},
+	EndpointDirectMessages: {15, time.Minute * 15},
+	EndpointVerify:         {75, time.Minute * 15},
+}
+
+// endpointOf returns the endpoint (Endpoint constant) for a request path like "/1.1/statuses/update.json"
+func endpointOf(path string) string {
+	endpoint := strings.TrimSuffix(strings.TrimPrefix(path, "/1.1/"), ".json")
+	if strings.HasPrefix(endpoint, "statuses/destroy/") {
+		return EndpointDelete
+	}
+	return endpoint
+}
+
+// rateLimitHeaders is a TwitterClient that keeps the header of its latest response from each endpoint
+// for reading the x-rate-limit headers (anaconda only returns them with errors)
+type rateLimitHeaders interface {
+	RateLimitHeader(endpoint string) http.Header
+}
+
+// RateLimit is the state of one account's limit for one endpoint
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// rateLimits stores the rate limits for each account and endpoint
+type rateLimits struct {
+	sync.Mutex
+	limits map[string]map[string]RateLimit
+}
+
+// RateLimitError is returned instead of sending when an account's rate limit for an endpoint has been used up
+type RateLimitError struct {
+	Account  string
+	Endpoint string
+	Reset    time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit for %s on %s reached, resets at %s", e.Account, e.Endpoint, e.Reset.Format("15:04:05"))
+}
+
+// IsRateLimit returns whether err means a rate limit was reached (by us or by Twitter)
+func IsRateLimit(err error) bool {
+	switch e := err.(type) {
+	case *RateLimitError:
+		return true
+	case *anaconda.ApiError:
+		isRateLimit, _ := e.RateLimitCheck()
+		return isRateLimit
+	}
+	return false
+}
+
+// RateLimit returns the current rate limit for account and endpoint (with the default limit if nothing has been sent yet)
+func (a *TwitterApp) RateLimit(account, endpoint string) RateLimit {
+	a.rateLimits.Lock()
+	defer a.rateLimits.Unlock()
+	return a.currentRateLimit(account, endpoint, time.Now())
+}
+
+// currentRateLimit returns the rate limit at now, starting a new window if the last one has reset (rateLimits lock must be held)
+func (a *TwitterApp) currentRateLimit(account, endpoint string, now time.Time) RateLimit {
+	limit, ok := a.rateLimits.limits[account][endpoint]
+	if !ok || !now.Before(limit.Reset) {
+		defaults := defaultRateLimits[endpoint]
+		if limit.Limit == 0 {
+			limit.Limit = defaults.limit
+		}
+		limit.Remaining = limit.Limit
+		limit.Reset = now.Add(defaults.window)
+	}
+	return limit
+}
+
+// checkRateLimit returns a RateLimitError if account has no requests left for endpoint
+func (a *TwitterApp) checkRateLimit(account, endpoint string) error {
+	limit := a.RateLimit(account, endpoint)
+	if limit.Limit > 0 && limit.Remaining <= 0 {
+		return &RateLimitError{account, endpoint, limit.Reset}
+	}
+	return nil
+}
+
+// callAPI calls the Twitter API for endpoint with account's client, unless its rate limit has been used up
+// (then it returns a RateLimitError), and updates the rate limit from the response
+func (a *TwitterApp) callAPI(account, endpoint string, client TwitterClient, call func() error) error {
+	if err := a.checkRateLimit(account, endpoint); err != nil {
+		return err
+	}
+	err := call()
+	a.recordRateLimit(account, endpoint, client, err)
+	return err
+}
+
+// recordRateLimit updates the rate limit for account and endpoint after a request with client that returned err
+// It's updated from the x-rate-limit headers when the response had them, otherwise successful requests are counted
+func (a *TwitterApp) recordRateLimit(account, endpoint string, client TwitterClient, err error) {
+	a.rateLimits.Lock()
+	defer a.rateLimits.Unlock()
+
+	if a.rateLimits.limits == nil {
+		a.rateLimits.limits = make(map[string]map[string]RateLimit)
+	}
+	if a.rateLimits.limits[account] == nil {
+		a.rateLimits.limits[account] = make(map[string]RateLimit)
+	}
+	now := time.Now()
+	limit := a.currentRateLimit(account, endpoint, now)
+
+	apiErr, ok := err.(*anaconda.ApiError)
+	switch {
+	case err == nil:
+		if !limit.update(latestHeader(client, endpoint)) {
+			limit.Remaining--
+		}
+	case ok:
+		limit.update(apiErr.Header)
+		if isRateLimit, reset := apiErr.RateLimitCheck(); isRateLimit {
+			limit.Remaining = 0
+			if reset.After(now) {
+				limit.Reset = reset
+			}
+		}
+	default:
+		// no response, so it doesn't count
+		return
+	}
+	a.rateLimits.limits[account][endpoint] = limit
+}
+
+// latestHeader returns the header of client's latest response from endpoint (nil if it doesn't keep them)
+func latestHeader(client TwitterClient, endpoint string) http.Header {
+	if headers, ok := client.(rateLimitHeaders); ok {
+		return headers.RateLimitHeader(endpoint)
+	}
+	return nil
+}
+
+// update sets the limit from the x-rate-limit headers in header, returning whether there were any
+func (limit *RateLimit) update(header http.Header) bool {
+	found := false
+	if value, err := strconv.Atoi(header.Get("X-Rate-Limit-Limit")); err == nil {
+		limit.Limit = value
+		found = true
+	}
+	if value, err := strconv.Atoi(header.Get("X-Rate-Limit-Remaining")); err == nil {
+		limit.Remaining = value
+		found = true
+	}
+	if value, err := strconv.ParseInt(header.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+		limit.Reset = time.Unix(value, 0)
+		found = true
+	}
+	return found
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPollRespectsRateLimits(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	a.config.Timeline = TimelineSettings{Enabled: true, Mentions: true, DirectMessages: true}
+	a.inbox.sinceIDs = make(map[string]string)
+	server.limit(EndpointMentions, 2)
+	server.mention("@me", "@you", "@me hi")
+
+	for i := 0; i < 4; i++ {
+		a.Poll()
+	}
+	if count := server.requestCount(EndpointMentions); count != 2 {
+		t.Errorf("mentions were checked %d times, want 2 (the rate limit)", count)
+	}
+	if count := server.requestCount(EndpointDirectMessages); count != 4 {
+		t.Errorf("direct messages were checked %d times, want 4", count)
+	}
+	limit := a.RateLimit("@me", EndpointMentions)
+	if limit.Limit != 2 || limit.Remaining != 0 {
+		t.Errorf("mentions rate limit is %+v, want the one from the headers", limit)
+	}
+	// direct messages didn't have headers, so they're counted against the estimate
+	if limit := a.RateLimit("@me", EndpointDirectMessages); limit.Remaining != defaultRateLimits[EndpointDirectMessages].limit-4 {
+		t.Errorf("direct messages rate limit is %+v, want 4 used", limit)
+	}
+}
+
+func TestSendsCountAgainstRateLimits(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	server.limit(EndpointVerify, 10)
+	account, _ := a.Account("@me")
+	a.InitTwitterAPI(account)
+
+	id, err := a.PostTweet("@me", "Hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteTweet("@me", id); err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range []string{EndpointTweet, EndpointDelete} {
+		if limit := a.RateLimit("@me", endpoint); limit.Remaining != defaultRateLimits[endpoint].limit-1 {
+			t.Errorf("%s rate limit is %+v, want 1 used", endpoint, limit)
+		}
+	}
+	if limit := a.RateLimit("@me", EndpointVerify); limit.Limit != 10 || limit.Remaining != 9 {
+		t.Errorf("%s rate limit is %+v, want the one from the headers", EndpointVerify, limit)
+	}
+}
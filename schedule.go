@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schedulerInterval is how often the scheduler checks for stored tweets that are due
+var schedulerInterval = time.Second * 20
+
+// scheduleTimeFormat is the format for one-off schedule times (in the schedule's timezone)
+const scheduleTimeFormat = "2006-01-02 15:04"
+
+// cronDescriptors are shortcuts for common cron specs
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// scheduler stores the next time each scheduled tweet is due, keyed by tweet ID
+// specs stores the schedule each next time was worked out from so changes to the config are noticed,
+// and checked is when the scheduler last checked (so schedules changed since then are due from then)
+type scheduler struct {
+	sync.Mutex
+	next    map[string]time.Time
+	specs   map[string]TweetSchedule
+	checked time.Time
+	stop    chan bool
+}
+
+// cronSchedule is a parsed cron spec, each field is the set of allowed values
+// anyDayOfMonth and anyDayOfWeek are whether those fields allow every day (e.g. * or 1-31)
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek map[int]bool
+	anyDayOfMonth, anyDayOfWeek                bool
+}
+
+// StartScheduler starts the goroutine that sends scheduled tweets (if it's not already running)
+// It reads the schedules from the config each time it checks so it keeps working when the config changes.
+// The schedules are due from when they're loaded, so a time soon after starting isn't missed
+func (a *TwitterApp) StartScheduler() {
+	a.scheduler.Lock()
+	defer a.scheduler.Unlock()
+	if a.scheduler.stop != nil {
+		return
+	}
+	now := time.Now()
+	a.updateSchedules(a.Config().Tweets, now)
+	a.scheduler.checked = now
+	a.scheduler.stop = make(chan bool)
+
+	go func(stop chan bool) {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.checkSchedules(time.Now())
+			case <-stop:
+				return
+			}
+		}
+	}(a.scheduler.stop)
+}
+
+// StopScheduler stops the scheduler goroutine
+func (a *TwitterApp) StopScheduler() {
+	a.scheduler.Lock()
+	defer a.scheduler.Unlock()
+	if a.scheduler.stop != nil {
+		close(a.scheduler.stop)
+		a.scheduler.stop = nil
+	}
+}
+
+// UpdateSchedules works out when new and changed schedules are next due from now, so one that's due before
+// the scheduler next checks is still sent. It's called whenever the stored tweets change
+func (a *TwitterApp) UpdateSchedules() {
+	a.scheduler.Lock()
+	defer a.scheduler.Unlock()
+	a.updateSchedules(a.Config().Tweets, time.Now())
+}
+
+// updateSchedules works out when the new and changed schedules in tweets are next due after since
+// and forgets the schedules that have gone (scheduler lock must be held)
+func (a *TwitterApp) updateSchedules(tweets map[string]TweetDetails, since time.Time) {
+	if a.scheduler.next == nil {
+		a.scheduler.next = make(map[string]time.Time)
+		a.scheduler.specs = make(map[string]TweetSchedule)
+	}
+	scheduled := make(map[string]bool)
+	for name, tweet := range tweets {
+		if tweet.Schedule == nil {
+			continue
+		}
+		scheduled[tweet.ID] = true
+		if spec, ok := a.scheduler.specs[tweet.ID]; ok && spec == *tweet.Schedule {
+			continue
+		}
+		next, err := tweet.Schedule.Next(since)
+		if err != nil {
+			log.Errorf("Invalid schedule for %s: %v", name, err)
+		}
+		a.scheduler.next[tweet.ID] = next
+		a.scheduler.specs[tweet.ID] = *tweet.Schedule
+	}
+	for id := range a.scheduler.next {
+		if !scheduled[id] {
+			delete(a.scheduler.next, id)
+			delete(a.scheduler.specs, id)
+		}
+	}
+}
+
+// checkSchedules sends the scheduled tweets that are due at now (including ones that became due since
+// they were saved) and works out when they are next due
+func (a *TwitterApp) checkSchedules(now time.Time) {
+	a.scheduler.Lock()
+	defer a.scheduler.Unlock()
+
+	// schedules that changed without UpdateSchedules being called are due from the last check
+	tweets := a.Config().Tweets
+	since := a.scheduler.checked
+	if since.IsZero() {
+		since = now
+	}
+	a.updateSchedules(tweets, since)
+	a.scheduler.checked = now
+
+	for name, tweet := range tweets {
+		if tweet.Schedule == nil {
+			continue
+		}
+		next := a.scheduler.next[tweet.ID]
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+
+		log.Infof("Sending scheduled tweet %s (due %v)", name, next)
+		go a.SendStoredTweet(name, nil)
+		a.scheduler.next[tweet.ID], _ = tweet.Schedule.Next(now)
+	}
+}
+
+// NextScheduled returns when the stored tweet called name is next due (zero time if it isn't scheduled)
+func (a *TwitterApp) NextScheduled(name string) time.Time {
+	a.scheduler.Lock()
+	defer a.scheduler.Unlock()
+	tweet, ok := a.StoredTweet(name)
+	if !ok || tweet.Schedule == nil {
+		return time.Time{}
+	}
+	if next, ok := a.scheduler.next[tweet.ID]; ok && a.scheduler.specs[tweet.ID] == *tweet.Schedule {
+		return next
+	}
+	// not checked by the scheduler yet
+	next, _ := tweet.Schedule.Next(time.Now())
+	return next
+}
+
+// Validate checks the schedule's timezone, time and cron spec
+func (s *TweetSchedule) Validate() error {
+	location, err := s.location()
+	if err != nil {
+		return err
+	}
+	if s.At != "" {
+		if _, err := time.ParseInLocation(scheduleTimeFormat, s.At, location); err != nil {
+			return fmt.Errorf("time must be like %s", scheduleTimeFormat)
+		}
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return err
+		}
+	}
+	if s.At == "" && s.Cron == "" {
+		return fmt.Errorf("schedule needs a time or a cron spec")
+	}
+	return nil
+}
+
+// Next returns the first time after after that the schedule is due, or the zero time if it won't be due again
+// A one-off time and a cron spec can both be set, in which case the earliest is used
+func (s *TweetSchedule) Next(after time.Time) (time.Time, error) {
+	var next time.Time
+	location, err := s.location()
+	if err != nil {
+		return next, err
+	}
+	if s.At != "" {
+		at, err := time.ParseInLocation(scheduleTimeFormat, s.At, location)
+		if err != nil {
+			return next, err
+		}
+		if at.After(after) {
+			next = at
+		}
+	}
+	if s.Cron != "" {
+		cron, err := parseCron(s.Cron)
+		if err != nil {
+			return next, err
+		}
+		cronNext := cron.Next(after.In(location))
+		if next.IsZero() || (!cronNext.IsZero() && cronNext.Before(next)) {
+			next = cronNext
+		}
+	}
+	return next, nil
+}
+
+// location returns the schedule's timezone (local time if it's blank)
+func (s *TweetSchedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	return location, nil
+}
+
+// parseCron parses a standard 5 field cron spec (minute hour day-of-month month day-of-week) or a descriptor like @daily
+// Fields can be *, numbers, ranges (1-5), lists (1,3,5) and steps (*/15 or 0-30/10). Sunday is 0 (or 7)
+func parseCron(spec string) (*cronSchedule, error) {
+	if descriptor, ok := cronDescriptors[strings.TrimSpace(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q should have 5 fields", spec)
+	}
+	var err error
+	c := &cronSchedule{}
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dayOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dayOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if c.dayOfWeek[7] {
+		c.dayOfWeek[0] = true
+	}
+	c.anyDayOfMonth = allValues(c.dayOfMonth, 1, 31)
+	c.anyDayOfWeek = allValues(c.dayOfWeek, 0, 6)
+	return c, nil
+}
+
+// allValues checks whether the set of values from a cron field has every value from min to max
+func allValues(values map[int]bool, min, max int) bool {
+	for v := min; v <= max; v++ {
+		if !values[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCronField returns the set of values allowed by one cron field
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value in cron field %q", field)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range in cron field %q", field)
+				}
+			} else if step > 1 {
+				// e.g. 5/15 means from 5 to the maximum every 15
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("cron field %q is out of range %d-%d", field, min, max)
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// Next returns the first minute after after that matches the cron schedule (in after's location)
+// or the zero time if nothing matches within 5 years (e.g. 31st of February)
+// It goes by the wall clock, so when the clocks go forward a time that's skipped is due an hour later
+// (e.g. 2:30 is 3:30 when 2:00 becomes 3:00), and when they go back the repeated times are only due once
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	// t is the wall clock time (in UTC, which has no changes to skip or repeat times)
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, after.Location())
+		if wall := time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute(), 0, 0, time.UTC); wall.Before(t) {
+			// t was skipped when the clocks went forward
+			next = next.Add(t.Sub(wall))
+		}
+		// a repeated time that was before after isn't due again
+		if next.After(after) {
+			return next
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// matchesDay checks the day of month and day of week fields
+// like standard cron, if both are restricted then matching either is enough
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth[t.Day()]
+	dayOfWeek := c.dayOfWeek[int(t.Weekday())]
+	switch {
+	case c.anyDayOfMonth && c.anyDayOfWeek:
+		return true
+	case c.anyDayOfMonth:
+		return dayOfWeek
+	case c.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
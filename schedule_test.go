@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	for _, test := range []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"5", 0, 59, []int{5}},
+		{"1-5", 1, 31, []int{1, 2, 3, 4, 5}},
+		{"1,3,5", 0, 6, []int{1, 3, 5}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"0-30/10", 0, 59, []int{0, 10, 20, 30}},
+		{"5/20", 0, 59, []int{5, 25, 45}},
+		{"1-3,10-20/5,30", 1, 31, []int{1, 2, 3, 10, 15, 20, 30}},
+		{"*/5", 1, 12, []int{1, 6, 11}},
+	} {
+		values, err := parseCronField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q) returned %v", test.field, err)
+			continue
+		}
+		var got []int
+		for v := range values {
+			got = append(got, v)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseCronField(%q) is %v, want %v", test.field, got, test.want)
+		}
+	}
+
+	for _, field := range []string{"", "60", "5-1", "a", "1-b", "*/0", "*/x", "0-60", "1,,2"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("parseCronField(%q) worked, want an error", field)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "@often"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) worked, want an error", spec)
+		}
+	}
+	// Sunday is 0 or 7
+	c, err := parseCron("0 0 * * 7")
+	if err != nil || !c.dayOfWeek[0] {
+		t.Errorf("parseCron with Sunday as 7 is %+v, %v", c, err)
+	}
+	// fields that allow every day are the same as *
+	for _, spec := range []string{"0 0 * * *", "0 0 */1 * 0-6", "0 0 1-31 * */1", "0 0 * * 0-7", "@daily"} {
+		c, err := parseCron(spec)
+		if err != nil || !c.anyDayOfMonth || !c.anyDayOfWeek {
+			t.Errorf("parseCron(%q) is %+v, %v, want every day", spec, c, err)
+		}
+	}
+	if c, _ := parseCron("0 0 1-30 * 1-5"); c.anyDayOfMonth || c.anyDayOfWeek {
+		t.Errorf("parseCron with some days is %+v, want them restricted", c)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Friday 15 May 2015
+	after := time.Date(2015, 5, 15, 10, 20, 30, 0, time.UTC)
+	for _, test := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2015, 5, 15, 10, 21, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, 5, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2015, 5, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 7 * * *", time.Date(2015, 5, 16, 7, 0, 0, 0, time.UTC)},
+		{"0 7 * * 1-5", time.Date(2015, 5, 18, 7, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// when both days are restricted, either matches (the 20th or a Sunday)
+		{"0 12 20 * 0", time.Date(2015, 5, 17, 12, 0, 0, 0, time.UTC)},
+		{"0 12 16 * 1", time.Date(2015, 5, 16, 12, 0, 0, 0, time.UTC)},
+		// but a field that allows every day doesn't make every day match
+		{"0 12 */1 * 1", time.Date(2015, 5, 18, 12, 0, 0, 0, time.UTC)},
+		{"0 12 20 * 0-6", time.Date(2015, 5, 20, 12, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	} {
+		c, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) returned %v", test.spec, err)
+			continue
+		}
+		if got := c.Next(after); !got.Equal(test.want) {
+			t.Errorf("next time for %q is %v, want %v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestScheduleNextTimezones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+	for _, test := range []struct {
+		cron        string
+		after, want time.Time
+	}{
+		{"0 7 * * *", time.Date(2015, 5, 15, 12, 0, 0, 0, time.UTC), time.Date(2015, 5, 16, 11, 0, 0, 0, time.UTC)},
+		// 2:30 is skipped when the clocks go forward on 8 March 2015, so it's due at 3:30
+		{"30 2 * * *", time.Date(2015, 3, 8, 0, 0, 0, 0, newYork), time.Date(2015, 3, 8, 3, 30, 0, 0, newYork)},
+		{"30 2 * * *", time.Date(2015, 3, 8, 3, 30, 0, 0, newYork), time.Date(2015, 3, 9, 2, 30, 0, 0, newYork)},
+		// 1:30 happens twice when the clocks go back on 1 November 2015 (5:30 and 6:30 UTC), it's only due the first time
+		{"30 1 * * *", time.Date(2015, 11, 1, 0, 0, 0, 0, newYork), time.Date(2015, 11, 1, 5, 30, 0, 0, time.UTC)},
+		{"30 1 * * *", time.Date(2015, 11, 1, 5, 30, 0, 0, time.UTC), time.Date(2015, 11, 2, 6, 30, 0, 0, time.UTC)},
+		{"0,30 * * * *", time.Date(2015, 11, 1, 5, 45, 0, 0, time.UTC), time.Date(2015, 11, 1, 7, 0, 0, 0, time.UTC)},
+		{"50 1 * * *", time.Date(2015, 11, 1, 6, 40, 0, 0, time.UTC), time.Date(2015, 11, 2, 6, 50, 0, 0, time.UTC)},
+	} {
+		schedule := TweetSchedule{Cron: test.cron, Timezone: "America/New_York"}
+		if got, err := schedule.Next(test.after); err != nil || !got.Equal(test.want) {
+			t.Errorf("next time for %q after %v is %v, %v, want %v", test.cron, test.after, got.UTC(), err, test.want.UTC())
+		}
+	}
+
+	// a one-off time is in the schedule's timezone, and the earliest of it and the cron spec is used
+	schedule := TweetSchedule{At: "2015-05-15 09:00", Cron: "0 10 * * *", Timezone: "America/New_York"}
+	after := time.Date(2015, 5, 15, 12, 0, 0, 0, time.UTC)
+	if got, _ := schedule.Next(after); !got.Equal(time.Date(2015, 5, 15, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("next time for %+v is %v, want the one-off time", schedule, got.UTC())
+	}
+	if got, _ := schedule.Next(after.Add(time.Hour)); !got.Equal(time.Date(2015, 5, 15, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("next time for %+v after the one-off time is %v, want the cron time", schedule, got.UTC())
+	}
+}
+
+// waitForSent waits until the stored tweet called name has been sent count times (the scheduler sends in the background)
+func waitForSent(t *testing.T, a *TwitterApp, name string, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if tweet, _ := a.StoredTweet(name); tweet.Number >= count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s wasn't sent %d times", name, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckSchedules(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server,
+		TweetDetails{Name: "hello", Message: "Hello", Schedule: &TweetSchedule{Cron: "0 7 * * *", Timezone: "UTC"}},
+		TweetDetails{Name: "missed", Message: "Missed", Schedule: &TweetSchedule{At: "2015-05-15 06:00", Timezone: "UTC"}})
+	loaded := time.Date(2015, 5, 15, 6, 59, 0, 0, time.UTC)
+	a.scheduler.Lock()
+	a.updateSchedules(a.Config().Tweets, loaded)
+	a.scheduler.checked = loaded
+	a.scheduler.Unlock()
+
+	// due since it was loaded, the one-off time before it was loaded isn't
+	a.checkSchedules(loaded.Add(90 * time.Second))
+	waitForSent(t, a, "hello", 1)
+	if tweets := server.tweeted("@me"); !reflect.DeepEqual(tweets, []string{"Hello 1"}) {
+		t.Fatalf("tweets are %q, want the scheduled one", tweets)
+	}
+	if next := a.NextScheduled("hello"); !next.Equal(time.Date(2015, 5, 16, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("next time after sending is %v, want tomorrow", next)
+	}
+	a.checkSchedules(loaded.Add(2 * time.Minute))
+
+	// changed without UpdateSchedules, it's due from the last check
+	a.updateConfig(func(m *TwitterAppModel) error {
+		tweet := m.Tweets["hello"]
+		tweet.Schedule = &TweetSchedule{Cron: "2 7 * * *", Timezone: "UTC"}
+		m.Tweets["hello"] = tweet
+		return nil
+	})
+	a.checkSchedules(loaded.Add(4 * time.Minute))
+	waitForSent(t, a, "hello", 2)
+	if tweets := server.tweeted("@me"); len(tweets) != 2 || tweets[1] != "Hello 2" {
+		t.Errorf("tweets are %q, want the changed schedule sent once", tweets)
+	}
+}
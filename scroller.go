@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/ninjasphere/sphere-go-led-controller/fonts/O4b03b"
+)
+
+// charWidth is roughly how wide each O4b03b character is (including the space after it)
+const charWidth = 4
+
+// default scrolling speed (time to move one pixel) and colour
+const defaultScrollSpeed = 60
+const defaultScrollColour = "#FFFA00"
+
+// TextScroller draws text scrolling from right to left across the LED matrix at row y
+type TextScroller struct {
+	text    string
+	colour  color.RGBA
+	speed   time.Duration
+	y       int
+	started time.Time
+}
+
+// NewTextScroller creates a scroller for text that starts scrolling now
+// speed is the time it takes to move one pixel
+func NewTextScroller(text string, colour color.RGBA, speed time.Duration, y int) *TextScroller {
+	return &TextScroller{
+		text:    text,
+		colour:  colour,
+		speed:   speed,
+		y:       y,
+		started: time.Now(),
+	}
+}
+
+// Draw draws the text at its current position (nothing once it has finished)
+func (s *TextScroller) Draw(img *image.RGBA) {
+	if !s.Finished() {
+		// the font only draws the pixels that are inside the image, so x can be negative
+		O4b03b.Font.DrawString(img, s.x(), s.y, s.text, s.colour)
+	}
+}
+
+// Restart starts scrolling the text from the right side again
+func (s *TextScroller) Restart() {
+	s.started = time.Now()
+}
+
+// Finished returns whether the text has scrolled off the left side
+func (s *TextScroller) Finished() bool {
+	return s.x()+len(s.text)*charWidth < 0
+}
+
+// Duration returns how long the text takes to scroll all the way across
+func (s *TextScroller) Duration() time.Duration {
+	return time.Duration(16+len(s.text)*charWidth) * s.speed
+}
+
+// x returns the current position of the start of the text, starting at the right side of the 16 pixel wide matrix
+func (s *TextScroller) x() int {
+	return 16 - int(time.Since(s.started)/s.speed)
+}
+
+// displaySettings returns the display settings from the config
+func (a *TwitterApp) displaySettings() DisplaySettings {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config.Display
+}
+
+// scrollSettings returns the speed and colour for scrolling text from the display settings (or the defaults)
+func (a *TwitterApp) scrollSettings() (time.Duration, color.RGBA) {
+	display := a.displaySettings()
+	speed := display.ScrollSpeed
+	if speed <= 0 {
+		speed = defaultScrollSpeed
+	}
+	colour, err := parseColour(display.ScrollColour)
+	if err != nil {
+		colour, _ = parseColour(defaultScrollColour)
+	}
+	return time.Duration(speed) * time.Millisecond, colour
+}
+
+// confirmTime returns how long there is to confirm sending, or 0 if confirming is turned off
+func (a *TwitterApp) confirmTime() time.Duration {
+	display := a.displaySettings()
+	if !display.ConfirmSend {
+		return 0
+	}
+	seconds := display.ConfirmTime
+	if seconds <= 0 {
+		seconds = defaultConfirmTime
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// undoTime returns how long there is to undo sending a tweet, or 0 if undoing is turned off
+func (a *TwitterApp) undoTime() time.Duration {
+	display := a.displaySettings()
+	if !display.UndoSend {
+		return 0
+	}
+	seconds := display.UndoTime
+	if seconds <= 0 {
+		seconds = defaultUndoTime
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// check returns an error if the display settings have a value that can't be used (0 for the speed and times is the default)
+func (d DisplaySettings) check() error {
+	if d.ScrollSpeed < 0 {
+		return fmt.Errorf("Speed must be a number of milliseconds, not %d", d.ScrollSpeed)
+	}
+	if d.ScrollColour != "" {
+		if _, err := parseColour(d.ScrollColour); err != nil {
+			return err
+		}
+	}
+	if d.ConfirmTime < 0 {
+		return fmt.Errorf("Confirm time must be a number of seconds, not %d", d.ConfirmTime)
+	}
+	if d.UndoTime < 0 {
+		return fmt.Errorf("Undo time must be a number of seconds, not %d", d.UndoTime)
+	}
+	return nil
+}
+
+// parseColour parses a colour like "#FFA500"
+func parseColour(value string) (color.RGBA, error) {
+	colour := color.RGBA{A: 255}
+	_, err := fmt.Sscanf(value, "#%02x%02x%02x", &colour.R, &colour.G, &colour.B)
+	if err != nil {
+		return colour, fmt.Errorf("colour %q should be like #FFA500", value)
+	}
+	return colour, nil
+}
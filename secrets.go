@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lindsaymarkward/go-ninja/config"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// keyFile is a file containing the key material for encrypting account secrets
+// If it's not set, the Sphere's serial number is used (or a generated twitter.key file if there's no serial)
+var keyFile = config.String("", "twitter.key.file")
+
+// defaultKeyFile is generated when there's no key file or serial number
+const defaultKeyFile = "twitter.key"
+
+// encryptedPrefix marks a config value as encrypted (values without it are plaintext from older configs)
+const encryptedPrefix = "enc:"
+
+// secretKey is the AES key, made the first time it's needed
+var secretKey struct {
+	sync.Once
+	key []byte
+	err error
+}
+
+// loadSecretKey returns the AES-256 key for encrypting secrets, derived from the key file or serial number
+func loadSecretKey() ([]byte, error) {
+	secretKey.Do(func() {
+		var material []byte
+		switch {
+		case keyFile != "":
+			material, secretKey.err = readOrCreateKeyFile(keyFile)
+		case config.Serial() != "":
+			material = []byte(config.Serial())
+		default:
+			material, secretKey.err = readOrCreateKeyFile(defaultKeyFile)
+		}
+		if secretKey.err != nil {
+			return
+		}
+		key := sha256.Sum256(append([]byte(info.ID+":"), material...))
+		secretKey.key = key[:]
+	})
+	return secretKey.key, secretKey.err
+}
+
+// readOrCreateKeyFile reads the key file at path, creating it with random key material if it doesn't exist
+func readOrCreateKeyFile(path string) ([]byte, error) {
+	material, err := ioutil.ReadFile(path)
+	if err == nil {
+		return material, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Could not read key file %s: %v", path, err)
+	}
+	material = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, material, 0600); err != nil {
+		return nil, fmt.Errorf("Could not create key file %s: %v", path, err)
+	}
+	log.Infof("Created key file %s for encrypting account secrets", path)
+	return material, nil
+}
+
+// newCipher returns the AES-GCM cipher for encrypting secrets
+func newCipher() (cipher.AEAD, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isEncrypted returns whether a config value has been encrypted
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// encryptSecret encrypts value for storing in the config (blank and already encrypted values are returned unchanged)
+func encryptSecret(value string) (string, error) {
+	if value == "" || isEncrypted(value) {
+		return value, nil
+	}
+	gcm, err := newCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts a value from the config (values that aren't encrypted are returned unchanged)
+func decryptSecret(value string) (string, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret (has the key changed?): %v", err)
+	}
+	return string(plain), nil
+}
+
+// Encrypted returns a copy of the account with its secrets encrypted
+func (account AccountDetails) Encrypted() (AccountDetails, error) {
+	var err error
+	if account.ConsumerSecret, err = encryptSecret(account.ConsumerSecret); err != nil {
+		return account, err
+	}
+	account.AccessTokenSecret, err = encryptSecret(account.AccessTokenSecret)
+	return account, err
+}
+
+// Decrypted returns a copy of the account with its secrets decrypted, for using with the Twitter API
+func (account AccountDetails) Decrypted() (AccountDetails, error) {
+	var err error
+	if account.ConsumerSecret, err = decryptSecret(account.ConsumerSecret); err != nil {
+		return account, err
+	}
+	account.AccessTokenSecret, err = decryptSecret(account.AccessTokenSecret)
+	return account, err
+}
+
+// hasPlaintextSecrets returns whether the account has secrets that aren't encrypted yet (from an older config)
+func (account AccountDetails) hasPlaintextSecrets() bool {
+	return (account.ConsumerSecret != "" && !isEncrypted(account.ConsumerSecret)) ||
+		(account.AccessTokenSecret != "" && !isEncrypted(account.AccessTokenSecret))
+}
+
+// passphrasePrefix marks a secret encrypted with a passphrase for exporting (the salt is stored with it)
+const passphrasePrefix = "pass:"
+
+// passphrase keys are made with PBKDF2 (SHA-256) from the passphrase and a random salt
+const (
+	passphraseIterations = 100000
+	passphraseSaltSize   = 16
+)
+
+// passphraseCipher returns the AES-GCM cipher for passphrase with salt
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, passphraseIterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWithPassphrase encrypts a plaintext secret with passphrase (blank values are returned unchanged)
+func encryptWithPassphrase(value, passphrase string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	gcm, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(value), nil)
+	return passphrasePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptWithPassphrase decrypts a secret encrypted with encryptWithPassphrase (other values are returned unchanged)
+func decryptWithPassphrase(value, passphrase string) (string, error) {
+	if !strings.HasPrefix(value, passphrasePrefix) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, passphrasePrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < passphraseSaltSize {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+	gcm, err := passphraseCipher(passphrase, sealed[:passphraseSaltSize])
+	if err != nil {
+		return "", err
+	}
+	sealed = sealed[passphraseSaltSize:]
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret (is the passphrase right?)")
+	}
+	return string(plain), nil
+}
+
+// forExport returns a copy of the account for exporting - without its keys and secrets if passphrase is blank,
+// or with its secrets encrypted with passphrase instead of this Sphere's key
+func (account AccountDetails) forExport(passphrase string) (AccountDetails, error) {
+	if passphrase == "" {
+		return AccountDetails{Username: account.Username}, nil
+	}
+	account, err := account.Decrypted()
+	if err != nil {
+		return account, err
+	}
+	if account.ConsumerSecret, err = encryptWithPassphrase(account.ConsumerSecret, passphrase); err != nil {
+		return account, err
+	}
+	account.AccessTokenSecret, err = encryptWithPassphrase(account.AccessTokenSecret, passphrase)
+	return account, err
+}
+
+// fromExport returns a copy of an exported account with its secrets encrypted with this Sphere's key
+// Accounts exported without secrets (or with another Sphere's key) can't be imported
+func (account AccountDetails) fromExport(passphrase string) (AccountDetails, error) {
+	if account.ConsumerKey == "" || account.ConsumerSecret == "" || account.AccessToken == "" || account.AccessTokenSecret == "" {
+		return account, fmt.Errorf("it was exported without its keys and secrets")
+	}
+	if isEncrypted(account.ConsumerSecret) || isEncrypted(account.AccessTokenSecret) {
+		return account, fmt.Errorf("its secrets are encrypted with another Sphere's key, export it with a passphrase")
+	}
+	if strings.HasPrefix(account.ConsumerSecret, passphrasePrefix) && passphrase == "" {
+		return account, fmt.Errorf("its secrets need the passphrase it was exported with")
+	}
+	var err error
+	if account.ConsumerSecret, err = decryptWithPassphrase(account.ConsumerSecret, passphrase); err != nil {
+		return account, err
+	}
+	if account.AccessTokenSecret, err = decryptWithPassphrase(account.AccessTokenSecret, passphrase); err != nil {
+		return account, err
+	}
+	return account.Encrypted()
+}
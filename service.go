@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TwitterService is exported so other apps and drivers can send tweets and direct messages through this app
+// Method names are called with a lower case first letter, e.g. "postTweet"
+type TwitterService struct {
+	app *TwitterApp
+}
+
+// MessageRequest is the argument for postTweet and sendDirectMessage
+// Account is the username to send from, blank means the default account
+// Media is an optional image URL to attach to a tweet, or a file in the media directory (twitter.media.dir)
+type MessageRequest struct {
+	Message string `json:"message"`
+	To      string `json:"to"`
+	Account string `json:"account"`
+	Media   string `json:"media"`
+}
+
+// StoredTweetRequest is the argument for sendStoredTweet, Name is the name of a tweet stored in the config
+type StoredTweetRequest struct {
+	Name string `json:"name"`
+}
+
+// HistoryRequest is the argument for exportHistory, Format is "csv" or "json"
+type HistoryRequest struct {
+	Format string `json:"format"`
+}
+
+// ConfigExportRequest is the argument for exportConfig, Format is "json" or "yaml"
+// Passphrase encrypts the accounts' secrets (blank leaves them out)
+type ConfigExportRequest struct {
+	Format     string `json:"format"`
+	Passphrase string `json:"passphrase"`
+}
+
+// ConfigImportRequest is the argument for importConfig, Config is an export (JSON or YAML)
+type ConfigImportRequest struct {
+	ImportOptions
+	Config string `json:"config"`
+}
+
+// SendResult describes what was sent (or attempted) and whether it worked
+// Queued is true if it failed with a temporary error and will be retried
+// ID is the status ID of a sent public tweet
+type SendResult struct {
+	Success bool      `json:"success"`
+	Queued  bool      `json:"queued"`
+	ID      string    `json:"id,omitempty"`
+	Account string    `json:"account"`
+	To      string    `json:"to,omitempty"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// PostTweet sends request.Message as a public tweet
+func (s *TwitterService) PostTweet(request *MessageRequest) (*SendResult, error) {
+	if request.Message == "" {
+		return nil, fmt.Errorf("No message to tweet")
+	}
+	if err := checkMessageLength(request.Message, ""); err != nil {
+		return nil, err
+	}
+	if err := checkServiceMedia(request.Media); err != nil {
+		return nil, err
+	}
+	account, err := s.account(request.Account)
+	if err != nil {
+		return nil, err
+	}
+	// send errors are reported in the result
+	result, _ := s.app.Send(account, "", request.Message, request.Media)
+	return result, nil
+}
+
+// SendDirectMessage sends request.Message as a direct message to request.To
+func (s *TwitterService) SendDirectMessage(request *MessageRequest) (*SendResult, error) {
+	if request.Message == "" || request.To == "" {
+		return nil, fmt.Errorf("A direct message needs a message and a user to send it to")
+	}
+	if err := checkMessageLength(request.Message, request.To); err != nil {
+		return nil, err
+	}
+	account, err := s.account(request.Account)
+	if err != nil {
+		return nil, err
+	}
+	// send errors are reported in the result
+	result, _ := s.app.Send(account, addAt(request.To), request.Message, "")
+	return result, nil
+}
+
+// SendStoredTweet sends the tweet/message stored with request.Name as if it was chosen on the spheramid
+func (s *TwitterService) SendStoredTweet(request *StoredTweetRequest) (*SendResult, error) {
+	if _, ok := s.app.StoredTweet(request.Name); !ok {
+		return nil, fmt.Errorf("No stored tweet called %q", request.Name)
+	}
+	result, _ := s.app.SendStoredTweet(request.Name, nil)
+	return result, nil
+}
+
+// ListStoredTweets returns the names of the stored tweets in the order they are numbered on the spheramid
+func (s *TwitterService) ListStoredTweets() ([]string, error) {
+	return s.app.TweetNames(), nil
+}
+
+// ExportHistory returns the sent history (most recent first) as CSV or JSON
+func (s *TwitterService) ExportHistory(request *HistoryRequest) (string, error) {
+	return s.app.ExportHistory(request.Format)
+}
+
+// ExportConfig returns the stored tweets and settings for backing up or copying to another Sphere
+func (s *TwitterService) ExportConfig(request *ConfigExportRequest) (string, error) {
+	return s.app.ExportConfig(request.Format, request.Passphrase)
+}
+
+// ImportConfig imports an export from exportConfig and returns what happened
+func (s *TwitterService) ImportConfig(request *ConfigImportRequest) ([]string, error) {
+	return s.app.ImportConfig([]byte(request.Config), request.ImportOptions)
+}
+
+// checkServiceMedia returns an error unless media is blank, an http(s) URL or a file in the media directory,
+// so other apps can't make this app read and tweet any file on the Sphere
+func checkServiceMedia(media string) error {
+	if media == "" || strings.HasPrefix(media, "http://") || strings.HasPrefix(media, "https://") {
+		return nil
+	}
+	if mediaDir == "" {
+		return fmt.Errorf("Media must be an http(s) URL")
+	}
+	// symlinks are followed so they can't point outside the directory
+	dir, err := filepath.EvalSymlinks(mediaDir)
+	if err == nil {
+		dir, err = filepath.Abs(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("The media directory %s can't be used: %v", mediaDir, err)
+	}
+	path, err := filepath.EvalSymlinks(media)
+	if err == nil {
+		path, err = filepath.Abs(path)
+	}
+	if err != nil || !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return fmt.Errorf("Media must be an http(s) URL or a file in %s", mediaDir)
+	}
+	return nil
+}
+
+// account checks that username is a known account, blank is the default account
+func (s *TwitterService) account(username string) (string, error) {
+	if username == "" {
+		username = s.app.Config().DefaultAccount
+	}
+	username = addAt(username)
+	if _, ok := s.app.Account(username); !ok {
+		return "", fmt.Errorf("No Twitter account %q", username)
+	}
+	return username, nil
+}
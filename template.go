@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// TemplateData is what stored tweet messages can use as a template,
+// e.g. "Good morning, it's {{.Weekday}}" or "{{choose "Hi" "Hello"}}, it's {{.Temperature}} degrees"
+// Value and Temperature come from the event that triggered the tweet (blank if it wasn't triggered)
+type TemplateData struct {
+	Count       int
+	Time        string
+	Date        string
+	Weekday     string
+	Value       string
+	Temperature string
+}
+
+// templateFuncs are the functions that message templates can use
+var templateFuncs = template.FuncMap{
+	// choose returns one of its arguments at random
+	"choose": func(choices ...string) string {
+		if len(choices) == 0 {
+			return ""
+		}
+		return choices[rand.Intn(len(choices))]
+	},
+}
+
+// uniqueFields are template fields that are different every time a tweet is sent,
+// so messages that use them don't need the counter added to avoid duplicates
+var uniqueFields = map[string]bool{
+	"Count": true,
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// parseMessage parses a stored tweet message as a template that uses funcs (templateFuncs, or longestFuncs for measuring)
+func parseMessage(message string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New("message").Funcs(funcs).Option("missingkey=error").Parse(message)
+}
+
+// NewTemplateData creates the data for rendering tweet's message at now
+// event is the payload of the device event that triggered it (or nil)
+func NewTemplateData(tweet TweetDetails, now time.Time, event interface{}) TemplateData {
+	data := TemplateData{
+		Count:   tweet.Number,
+		Time:    now.Format("15:04"),
+		Date:    now.Format("2 Jan 2006"),
+		Weekday: now.Weekday().String(),
+	}
+	if event == nil {
+		return data
+	}
+
+	value := unwrapPayload(event)
+	if object, ok := value.(map[string]interface{}); ok {
+		if temperature, ok := object["temperature"]; ok {
+			data.Temperature = payloadString(unwrapPayload(temperature))
+		}
+	}
+	if tweet.Trigger != nil && tweet.Trigger.Field != "" {
+		if fieldValue, err := tweet.Trigger.value(event); err == nil {
+			value = fieldValue
+		}
+	}
+	data.Value = payloadString(value)
+	if _, err := strconv.ParseFloat(data.Value, 64); err == nil && data.Temperature == "" {
+		data.Temperature = data.Value
+	}
+	return data
+}
+
+// RenderMessage renders tweet's message template (or its current alternative) with data
+// then makes it unique with the tweet's strategy (see makeUnique)
+func RenderMessage(tweet TweetDetails, data TemplateData) (string, error) {
+	return renderMessage(tweet, data, templateFuncs, false)
+}
+
+// renderMessage is RenderMessage with the template functions funcs,
+// retrying is true when the last variation was rejected as a duplicate
+func renderMessage(tweet TweetDetails, data TemplateData, funcs template.FuncMap, retrying bool) (string, error) {
+	t, err := parseMessage(tweet.messageTemplate(), funcs)
+	if err != nil {
+		return "", err
+	}
+	var message bytes.Buffer
+	if err := t.Execute(&message, data); err != nil {
+		return "", err
+	}
+	return makeUnique(message.String(), tweet, usesFields(t.Tree.Root, uniqueFields), retrying), nil
+}
+
+// ValidateMessage checks that message is a valid template by rendering it with example data
+func ValidateMessage(message string) error {
+	_, err := RenderMessage(TweetDetails{Message: message}, NewTemplateData(TweetDetails{}, time.Now(), nil))
+	return err
+}
+
+// usesFields checks whether the template node (or any node inside it) uses one of fields, e.g. {{.Count}}
+func usesFields(node parse.Node, fields map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesFields(child, fields) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, command := range n.Cmds {
+			if usesFields(command, fields) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if usesFields(arg, fields) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && fields[n.Ident[0]]
+	case *parse.IfNode:
+		return usesFields(n.Pipe, fields) || usesFields(n.List, fields) || usesFields(n.ElseList, fields)
+	case *parse.WithNode:
+		return usesFields(n.Pipe, fields) || usesFields(n.List, fields) || usesFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		return usesFields(n.Pipe, fields) || usesFields(n.List, fields) || usesFields(n.ElseList, fields)
+	}
+	return false
+}
+
+// PreviewMessage returns what the stored tweet called name will look like when it's next sent
+func (a *TwitterApp) PreviewMessage(name string) (string, error) {
+	tweet, ok := a.StoredTweet(name)
+	if !ok {
+		return "", fmt.Errorf("There is no stored tweet called %s", name)
+	}
+	tweet.Number++
+	tweet.Variation++
+	return RenderMessage(tweet, NewTemplateData(tweet, time.Now(), nil))
+}
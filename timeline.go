@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// default and minimum time between polls (the home timeline and direct messages are limited to 15 requests
+// per 15 minutes, and an endpoint isn't polled again until its rate limit resets if it's used up)
+const defaultPollInterval = 120
+const minPollInterval = 60
+
+// maxNotifications is how many notifications are kept
+const maxNotifications = 50
+
+// kinds of notification
+const (
+	KindMention       = "mention"
+	KindDirectMessage = "dm"
+	KindTweet         = "tweet"
+)
+
+// Notification is a mention, direct message or home timeline tweet received by one of the accounts
+type Notification struct {
+	ID      string
+	Kind    string
+	Account string
+	Author  string
+	Text    string
+	Read    bool
+}
+
+// inbox stores the notifications (newest first) and the newest ID seen for each account and kind
+// so each poll only gets new ones
+type inbox struct {
+	sync.Mutex
+	notifications []Notification
+	sinceIDs      map[string]string
+	stop          chan bool
+}
+
+// StartPolling starts the goroutine that polls for mentions, direct messages and the home timeline (if it's not already running)
+func (a *TwitterApp) StartPolling() {
+	a.inbox.Lock()
+	defer a.inbox.Unlock()
+	if a.inbox.stop != nil {
+		return
+	}
+	a.inbox.sinceIDs = make(map[string]string)
+	a.inbox.stop = make(chan bool)
+
+	go func(stop chan bool) {
+		for {
+			if config := a.Config(); config.Timeline.Enabled || config.Commands.Enabled {
+				a.Poll()
+			}
+			select {
+			case <-time.After(a.pollInterval()):
+			case <-stop:
+				return
+			}
+		}
+	}(a.inbox.stop)
+}
+
+// StopPolling stops the polling goroutine
+func (a *TwitterApp) StopPolling() {
+	a.inbox.Lock()
+	defer a.inbox.Unlock()
+	if a.inbox.stop != nil {
+		close(a.inbox.stop)
+		a.inbox.stop = nil
+	}
+}
+
+// pollInterval returns the time between polls from the timeline settings
+func (a *TwitterApp) pollInterval() time.Duration {
+	seconds := a.Config().Timeline.PollInterval
+	if seconds == 0 {
+		seconds = defaultPollInterval
+	} else if seconds < minPollInterval {
+		seconds = minPollInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Poll gets new mentions, direct messages and home timeline tweets (whichever are turned on) for each working account
+// Direct messages are also checked for commands if they're turned on
+func (a *TwitterApp) Poll() {
+	config := a.Config()
+	timeline := config.Timeline
+	showDirectMessages := timeline.Enabled && timeline.DirectMessages
+	received := make(map[string][]anaconda.DirectMessage)
+	for _, username := range a.AccountNames() {
+		client, err := a.Client(username)
+		if err != nil {
+			continue
+		}
+		if timeline.Enabled && timeline.Mentions {
+			a.pollTweets(username, KindMention, EndpointMentions, client, client.GetMentions)
+		}
+		if timeline.Enabled && timeline.Home {
+			a.pollTweets(username, KindTweet, EndpointHomeTimeline, client, client.GetHomeTimeline)
+		}
+		if showDirectMessages || config.Commands.Enabled {
+			v, first := a.sinceValues(username, KindDirectMessage)
+			// commands sent while the app wasn't running are run on the first poll (if they're newer than the last one checked)
+			if first && config.Commands.Enabled {
+				v.Set("count", fmt.Sprintf("%d", maxNotifications))
+			}
+			var messages []anaconda.DirectMessage
+			err := a.callAPI(username, EndpointDirectMessages, client, func() (err error) {
+				messages, err = client.GetDirectMessages(v)
+				return err
+			})
+			if err != nil {
+				log.Errorf("Error getting direct messages for %s: %v", username, err)
+				continue
+			}
+			var notifications []Notification
+			for _, message := range messages {
+				notifications = append(notifications, Notification{
+					ID:      message.IdStr,
+					Kind:    KindDirectMessage,
+					Account: username,
+					Author:  addAt(message.SenderScreenName),
+					Text:    message.Text,
+				})
+			}
+			if config.Commands.Enabled {
+				received[username] = messages
+			}
+			a.addNotifications(username, KindDirectMessage, notifications, first || !showDirectMessages)
+		}
+	}
+	if len(received) > 0 {
+		a.handleCommands(a.newCommands(received))
+	}
+}
+
+// pollTweets gets new tweets of kind for account with get, which uses endpoint of client
+func (a *TwitterApp) pollTweets(account, kind, endpoint string, client TwitterClient, get func(url.Values) ([]anaconda.Tweet, error)) {
+	v, first := a.sinceValues(account, kind)
+	var tweets []anaconda.Tweet
+	err := a.callAPI(account, endpoint, client, func() (err error) {
+		tweets, err = get(v)
+		return err
+	})
+	if err != nil {
+		log.Errorf("Error getting %s tweets for %s: %v", kind, account, err)
+		return
+	}
+	var notifications []Notification
+	for _, tweet := range tweets {
+		notifications = append(notifications, Notification{
+			ID:      tweet.IdStr,
+			Kind:    kind,
+			Account: account,
+			Author:  addAt(tweet.User.ScreenName),
+			Text:    tweet.Text,
+		})
+	}
+	a.addNotifications(account, kind, notifications, first)
+}
+
+// sinceValues returns the request values for only getting new items of kind for account,
+// and whether this is the first poll (when only the newest is needed, to know where to start)
+func (a *TwitterApp) sinceValues(account, kind string) (url.Values, bool) {
+	a.inbox.Lock()
+	defer a.inbox.Unlock()
+	v := url.Values{}
+	sinceID, ok := a.inbox.sinceIDs[account+" "+kind]
+	if !ok {
+		v.Set("count", "1")
+		return v, true
+	}
+	v.Set("count", fmt.Sprintf("%d", maxNotifications))
+	v.Set("since_id", sinceID)
+	return v, false
+}
+
+// addNotifications adds new notifications (newest first) for account and kind
+// If trackOnly is set (e.g. on the first poll) they are only used for knowing where to start, so they're not added as unread
+func (a *TwitterApp) addNotifications(account, kind string, notifications []Notification, trackOnly bool) {
+	a.inbox.Lock()
+	defer a.inbox.Unlock()
+	key := account + " " + kind
+	if len(notifications) > 0 {
+		a.inbox.sinceIDs[key] = notifications[0].ID
+	} else if _, ok := a.inbox.sinceIDs[key]; !ok {
+		a.inbox.sinceIDs[key] = "1"
+	}
+	if trackOnly {
+		return
+	}
+	// add oldest first so the newest ends up at the start
+	for i := len(notifications) - 1; i >= 0; i-- {
+		// don't add the account's own tweets
+		if notifications[i].Author == account {
+			continue
+		}
+		log.Infof("New %s for %s from %s: %s", kind, account, notifications[i].Author, notifications[i].Text)
+		a.inbox.notifications = append([]Notification{notifications[i]}, a.inbox.notifications...)
+	}
+	if len(a.inbox.notifications) > maxNotifications {
+		a.inbox.notifications = a.inbox.notifications[:maxNotifications]
+	}
+}
+
+// Unread returns the unread notifications, newest first
+func (a *TwitterApp) Unread() []Notification {
+	a.inbox.Lock()
+	defer a.inbox.Unlock()
+	var unread []Notification
+	for _, notification := range a.inbox.notifications {
+		if !notification.Read {
+			unread = append(unread, notification)
+		}
+	}
+	return unread
+}
+
+// MarkRead marks the notification with id as read
+func (a *TwitterApp) MarkRead(id string) {
+	a.inbox.Lock()
+	defer a.inbox.Unlock()
+	for i := range a.inbox.notifications {
+		if a.inbox.notifications[i].ID == id {
+			a.inbox.notifications[i].Read = true
+		}
+	}
+}
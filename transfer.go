@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxImportSize is the largest export that can be imported (they're usually a few KB)
+const maxImportSize = 10 * 1024 * 1024
+
+// importClient downloads exports from URLs
+var importClient = &http.Client{Timeout: time.Second * 30}
+
+// ExportYAML is the other format the config can be exported in (as well as ExportJSON)
+const ExportYAML = "yaml"
+
+// Import modes - merge adds the imported tweets to the stored ones, replace deletes the stored ones first
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+)
+
+// What to do when merging a tweet with the same name as a stored one - skip it, overwrite the stored one
+// or add it with a number after its name
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+// ImportOptions are how to import an exported config (Mode and Conflicts constants)
+// Settings imports the display, gestures, timeline and commands settings too
+// Passphrase is for the accounts' secrets, if they were exported with one
+type ImportOptions struct {
+	Mode       string `json:"mode"`
+	Conflicts  string `json:"conflicts"`
+	Settings   bool   `json:"settings"`
+	Passphrase string `json:"passphrase"`
+}
+
+// ExportConfig returns the config as JSON or YAML (ExportJSON or ExportYAML) for backing up or copying to another Sphere
+// The accounts are exported without their keys and secrets, unless there's a passphrase to encrypt the secrets with.
+// Messages waiting to be retried and the direct message IDs checked for commands aren't exported
+func (a *TwitterApp) ExportConfig(format, passphrase string) (string, error) {
+	export := a.Config()
+	export.Pending = nil
+	export.Commands.LastIDs = nil
+	for username, account := range export.Accounts {
+		account, err := account.forExport(passphrase)
+		if err != nil {
+			return "", fmt.Errorf("Could not export %s: %v", username, err)
+		}
+		export.Accounts[username] = account
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case ExportJSON:
+		return string(data), nil
+	case ExportYAML:
+		data, err = jsonToYAML(data)
+		return string(data), err
+	}
+	return "", fmt.Errorf("Unknown export format %q, use %s or %s", format, ExportJSON, ExportYAML)
+}
+
+// ImportConfig imports an exported config (JSON or YAML) as options says, and returns what happened
+// Older exports are upgraded first, and accounts that aren't stored yet are added if they have their secrets
+func (a *TwitterApp) ImportConfig(data []byte, options ImportOptions) ([]string, error) {
+	imported, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, fix := range imported.repair() {
+		log.Infof("Repaired imported config: %s", fix)
+	}
+	imported.migrate(true)
+
+	var report []string
+	accounts := make(map[string]AccountDetails)
+	for username, account := range imported.Accounts {
+		account, err := account.fromExport(options.Passphrase)
+		if err != nil {
+			report = append(report, fmt.Sprintf("Skipped the account %s: %v", username, err))
+			continue
+		}
+		accounts[username] = account
+	}
+
+	var added []string
+	a.updateConfig(func(m *TwitterAppModel) error {
+		var changes []string
+		changes, added = m.importConfig(imported, accounts, options)
+		report = append(report, changes...)
+		return nil
+	})
+	for _, username := range added {
+		if account, ok := a.Account(username); ok {
+			go a.InitTwitterAPI(account)
+		}
+	}
+	a.UpdateTriggers()
+	a.UpdateSchedules()
+	return report, nil
+}
+
+// importConfig adds the tweets and groups (and settings if options.Settings) from imported to m as options says,
+// and the accounts that m doesn't have. It returns what happened and the usernames of the added accounts
+func (m *TwitterAppModel) importConfig(imported *TwitterAppModel, accounts map[string]AccountDetails, options ImportOptions) ([]string, []string) {
+	var report, added []string
+	for _, username := range (&TwitterAppModel{Accounts: accounts}).AccountNames() {
+		if _, ok := m.Accounts[username]; ok {
+			report = append(report, fmt.Sprintf("Kept the account %s that was already here", username))
+			continue
+		}
+		m.Accounts[username] = accounts[username]
+		if m.DefaultAccount == "" {
+			m.DefaultAccount = username
+		}
+		added = append(added, username)
+		report = append(report, fmt.Sprintf("Added the account %s", username))
+	}
+
+	if options.Mode == ImportReplace {
+		report = append(report, fmt.Sprintf("Deleted the %d stored tweets", len(m.TweetNames)))
+		m.Tweets = make(map[string]TweetDetails)
+		m.TweetNames = nil
+		m.Groups = nil
+	}
+	for _, group := range imported.Groups {
+		if !m.hasGroup(group) {
+			m.Groups = append(m.Groups, group)
+		}
+	}
+
+	for _, name := range imported.TweetNames {
+		tweet := imported.Tweets[name]
+		tweet.ID = ""
+		action := "Added"
+		if existing, ok := m.Tweets[name]; ok {
+			switch options.Conflicts {
+			case ConflictOverwrite:
+				tweet.ID = existing.ID
+				action = "Replaced"
+			case ConflictRename:
+				tweet.Name = m.unusedTweetName(name, imported.Tweets)
+				action = "Added " + name + " as"
+			default:
+				report = append(report, fmt.Sprintf("Skipped %s, there is already a tweet with that name", name))
+				continue
+			}
+		}
+		if err := m.saveTweet(tweet); err != nil {
+			report = append(report, fmt.Sprintf("Skipped %s: %v", name, err))
+			continue
+		}
+		report = append(report, fmt.Sprintf("%s %s", action, tweet.Name))
+	}
+
+	if options.Settings {
+		if err := imported.checkSettings(); err != nil {
+			report = append(report, fmt.Sprintf("Skipped the display, gestures, timeline and commands settings: %v", err))
+			return report, added
+		}
+		m.Display = imported.Display
+		m.Timeline = imported.Timeline
+		// like turning commands on, messages sent before the import aren't run
+		if imported.Commands.Enabled && !m.Commands.Enabled {
+			m.Commands.LastIDs = nil
+		}
+		m.Commands.Enabled = imported.Commands.Enabled
+		m.Commands.AllowList = append([]string(nil), imported.Commands.AllowList...)
+		m.Gestures = make(map[string]string, len(imported.Gestures))
+		for gesture, action := range imported.Gestures {
+			m.Gestures[gesture] = action
+		}
+		report = append(report, "Replaced the display, gestures, timeline and commands settings")
+	}
+	return report, added
+}
+
+// checkSettings returns an error if the imported display or gestures settings can't be used, like the settings screens
+func (m *TwitterAppModel) checkSettings() error {
+	if err := m.Display.check(); err != nil {
+		return err
+	}
+	return checkGestures(m.Gestures)
+}
+
+// unusedTweetName returns name with the lowest number after it (from 2) that isn't the name of a stored tweet
+// or one of the importing tweets (so a renamed tweet doesn't take the name of one that's imported after it)
+func (m *TwitterAppModel) unusedTweetName(name string, importing map[string]TweetDetails) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		_, stored := m.Tweets[candidate]
+		_, imported := importing[candidate]
+		if !stored && !imported {
+			return candidate
+		}
+	}
+}
+
+// parseConfig reads an exported config, which is JSON if it starts with "{" and YAML otherwise
+func parseConfig(data []byte) (*TwitterAppModel, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		var err error
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("Could not read the YAML: %v", err)
+		}
+	}
+	var imported TwitterAppModel
+	if err := json.Unmarshal(data, &imported); err != nil {
+		return nil, fmt.Errorf("Could not read the config: %v", err)
+	}
+	return &imported, nil
+}
+
+// jsonToYAML converts JSON to YAML with the same keys (the config only has JSON field names)
+func jsonToYAML(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(value)
+}
+
+// yamlToJSON converts YAML to JSON, so it can be read into the config like JSON
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(stringKeys(value))
+}
+
+// stringKeys converts the map[interface{}]interface{} maps from YAML (and any inside them) to map[string]interface{}
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return value
+}
+
+// importSource returns the config to import from source, which is pasted JSON/YAML or a file path or URL
+func importSource(source string) ([]byte, error) {
+	source = strings.TrimSpace(source)
+	if strings.HasPrefix(source, "{") || strings.Contains(source, "\n") {
+		return []byte(source), nil
+	}
+	var reader io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		response, err := importClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("download failed: %s", response.Status)
+		}
+		reader = response.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	// one byte more than the limit is enough to know it's too big without reading all of it
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportSize {
+		return nil, fmt.Errorf("it's bigger than %d bytes, which is too big for an export", maxImportSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-ninja/suit"
+)
+
+func TestImportSource(t *testing.T) {
+	export := `{"tweetnames": ["hello"], "tweets": {"hello": {"name": "hello", "message": "Hello"}}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/export.json":
+			w.Write([]byte(export))
+		case "/big.json":
+			w.Write(bytes.Repeat([]byte(" "), maxImportSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "export.json")
+	if err := ioutil.WriteFile(path, []byte(export), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []string{export, path, server.URL + "/export.json"} {
+		if data, err := importSource(source); err != nil || string(data) != export {
+			t.Errorf("importing from %.40s returned %q, %v", source, data, err)
+		}
+	}
+	for _, source := range []string{server.URL + "/big.json", server.URL + "/missing.json", path + ".missing"} {
+		if _, err := importSource(source); err == nil || strings.Contains(err.Error(), "attach") {
+			t.Errorf("importing from %s returned %v, want an error about the export", source, err)
+		}
+	}
+}
+
+func TestImportChecksSettings(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+
+	for _, export := range []string{
+		`{"gestures": {"flickup": "explode"}, "display": {"scrollspeed": "50"}}`,
+		`{"gestures": {"wave": "send"}}`,
+		`{"gestures": {"airwheel": "send"}}`,
+		`{"display": {"scrollcolour": "orange"}}`,
+		`{"display": {"undotime": "-5"}}`,
+	} {
+		report, err := a.ImportConfig([]byte(export), ImportOptions{Mode: ImportMerge, Settings: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config := a.Config(); len(config.Gestures) != 0 || config.Display != (DisplaySettings{}) {
+			t.Errorf("importing %s changed the settings to %v, %+v", export, config.Gestures, config.Display)
+		}
+		if !strings.Contains(strings.Join(report, "\n"), "Skipped the display") {
+			t.Errorf("importing %s reported %q", export, report)
+		}
+	}
+
+	export := `{"gestures": {"flickup": "cancel"}, "display": {"scrollspeed": "50", "scrollcolour": "#FFA500"}}`
+	if _, err := a.ImportConfig([]byte(export), ImportOptions{Mode: ImportMerge, Settings: true}); err != nil {
+		t.Fatal(err)
+	}
+	if a.GestureAction(GestureFlickUp) != ActionCancel || a.Config().Display.ScrollSpeed != 50 {
+		t.Errorf("settings are %v, %+v after importing valid ones", a.Config().Gestures, a.Config().Display)
+	}
+}
+
+func TestConfigureImportExport(t *testing.T) {
+	server := newTwitterServer(t)
+	c := &ConfigService{newTestApp(t, server)}
+
+	screen := configure(t, c, "importExport", nil)
+	found := false
+	for _, section := range screen.Sections {
+		for _, content := range section.Contents {
+			if input, ok := content.(suit.InputText); ok && input.Name == "passphrase" {
+				found = true
+				if input.InputType != "password" {
+					t.Errorf("passphrase is shown as %q, want a password", input.InputType)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("there's no passphrase on the import/export screen")
+	}
+}
+
+// newImportModel returns a config with the tweets (name then message) and groups
+func newImportModel(t *testing.T, groups []string, tweets ...string) *TwitterAppModel {
+	m := &TwitterAppModel{Accounts: make(map[string]AccountDetails), Tweets: make(map[string]TweetDetails), Groups: groups}
+	for i := 0; i < len(tweets); i += 2 {
+		if err := m.saveTweet(TweetDetails{Name: tweets[i], Message: tweets[i+1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// tweetMessages returns the names and messages of m's tweets in order
+func tweetMessages(m *TwitterAppModel) []string {
+	var messages []string
+	for _, name := range m.TweetNames {
+		messages = append(messages, name, m.Tweets[name].Message)
+	}
+	return messages
+}
+
+func TestImportConfigTweets(t *testing.T) {
+	for _, test := range []struct {
+		options ImportOptions
+		want    []string
+		groups  []string
+	}{
+		{ImportOptions{Mode: ImportMerge, Conflicts: ConflictSkip},
+			[]string{"hello", "Hello", "bye", "Bye", "hello (2)", "Hello two", "new", "New"}, []string{"stored", "imported"}},
+		{ImportOptions{Mode: ImportMerge, Conflicts: ConflictOverwrite},
+			[]string{"hello", "Hi there", "bye", "Bye", "hello (2)", "Hello two", "new", "New"}, []string{"stored", "imported"}},
+		// the imported hello isn't renamed to "hello (2)", which is also being imported
+		{ImportOptions{Mode: ImportMerge, Conflicts: ConflictRename},
+			[]string{"hello", "Hello", "bye", "Bye", "hello (3)", "Hi there", "hello (2)", "Hello two", "new", "New"}, []string{"stored", "imported"}},
+		{ImportOptions{Mode: ImportReplace, Conflicts: ConflictSkip},
+			[]string{"hello", "Hi there", "hello (2)", "Hello two", "new", "New"}, []string{"imported", "stored"}},
+	} {
+		m := newImportModel(t, []string{"stored"}, "hello", "Hello", "bye", "Bye")
+		stored := m.Tweets["hello"].ID
+		imported := newImportModel(t, []string{"imported", "stored"}, "hello", "Hi there", "hello (2)", "Hello two", "new", "New")
+		m.importConfig(imported, nil, test.options)
+
+		if got := tweetMessages(m); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("importing with %+v gave tweets %q, want %q", test.options, got, test.want)
+		}
+		if !reflect.DeepEqual(m.Groups, test.groups) {
+			t.Errorf("importing with %+v gave groups %q, want %q", test.options, m.Groups, test.groups)
+		}
+		if test.options.Mode == ImportMerge && m.Tweets["hello"].ID != stored {
+			t.Errorf("importing with %+v changed the stored tweet's ID", test.options)
+		}
+		if fixes := m.repair(); len(fixes) != 0 {
+			t.Errorf("importing with %+v left things to repair: %q", test.options, fixes)
+		}
+	}
+}
+
+func TestUnusedTweetName(t *testing.T) {
+	m := newImportModel(t, nil, "hello", "Hello", "hello (2)", "Hello")
+	importing := map[string]TweetDetails{"hello (3)": {}}
+	if name := m.unusedTweetName("hello", importing); name != "hello (4)" {
+		t.Errorf("unused name is %q, want hello (4)", name)
+	}
+	if name := m.unusedTweetName("bye", nil); name != "bye (2)" {
+		t.Errorf("unused name is %q, want bye (2)", name)
+	}
+}
+
+func TestExportWithoutPassphrase(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+
+	exported, err := a.ExportConfig(ExportJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"key", "secret", "token-me"} {
+		if strings.Contains(exported, `"`+secret+`"`) {
+			t.Errorf("export without a passphrase has %q", secret)
+		}
+	}
+	export, _ := parseConfig([]byte(exported))
+	if account := export.Accounts["@me"]; account != (AccountDetails{Username: "@me"}) {
+		t.Errorf("exported account is %+v, want only its username", account)
+	}
+
+	// importing it elsewhere keeps the tweets but skips the account
+	b := newTestApp(t, newTwitterServer(t))
+	b.DeleteAccount("@me")
+	report, err := b.ImportConfig([]byte(exported), ImportOptions{Mode: ImportMerge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Account("@me"); ok || !strings.Contains(strings.Join(report, "\n"), "Skipped the account @me") {
+		t.Errorf("importing an account without secrets reported %q", report)
+	}
+	if tweet, ok := b.StoredTweet("hello"); !ok || tweet.Message != "Hello" {
+		t.Errorf("imported tweet is %+v", tweet)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{ExportJSON, ExportYAML} {
+		server := newTwitterServer(t)
+		a := newTestApp(t, server,
+			TweetDetails{Name: "hello", Message: "Hello: {{.Count}}", Alternatives: []string{"Hi", "G'day"}, Unique: UniqueAlternatives},
+			TweetDetails{Name: "door", Message: "Door is {{.Value}}", To: "@you", Group: "alerts",
+				Trigger:  &TweetTrigger{Topic: "door", Field: "state.open", Condition: ConditionEquals, Value: "true", Cooldown: 30},
+				Schedule: &TweetSchedule{Cron: "0 7 * * 1-5", Timezone: "UTC"}})
+		you := server.addUser("@you")
+		if err := a.SaveAccount(you, "", false); err != nil {
+			t.Fatal(err)
+		}
+
+		exported, err := a.ExportConfig(format, "correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(exported, "token-secret") {
+			t.Errorf("%s export has a secret that isn't encrypted", format)
+		}
+		if format == ExportYAML && json.Valid([]byte(exported)) {
+			t.Errorf("YAML export is JSON")
+		}
+
+		// a wrong passphrase skips the accounts
+		b := newTestApp(t, server)
+		report, err := b.ImportConfig([]byte(exported), ImportOptions{Mode: ImportReplace, Passphrase: "wrong"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := b.Account("@you"); ok || !strings.Contains(strings.Join(report, "\n"), "Skipped the account @you") {
+			t.Errorf("importing with the wrong passphrase reported %q", report)
+		}
+
+		c := newTestApp(t, server)
+		if _, err := c.ImportConfig([]byte(exported), ImportOptions{Mode: ImportReplace, Passphrase: "correct horse"}); err != nil {
+			t.Fatal(err)
+		}
+		account, ok := c.Account("@you")
+		if decrypted, err := account.Decrypted(); !ok || err != nil || decrypted != you {
+			t.Errorf("account imported from %s is %+v, %v, want %+v", format, decrypted, err, you)
+		}
+		for _, name := range a.TweetNames() {
+			want, _ := a.StoredTweet(name)
+			got, _ := c.StoredTweet(name)
+			want.ID, got.ID = "", ""
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("tweet imported from %s is %+v, want %+v", format, got, want)
+			}
+		}
+		if !reflect.DeepEqual(c.TweetNames(), a.TweetNames()) {
+			t.Errorf("tweets imported from %s are %q, want %q", format, c.TweetNames(), a.TweetNames())
+		}
+		for deadline := time.Now().Add(5 * time.Second); !c.IsInitialised("@you") && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/go-ninja/api"
+)
+
+// defaultTriggerCooldown is used when a trigger doesn't set its own cooldown
+var defaultTriggerCooldown = time.Second * 60
+
+// trigger conditions (TweetTrigger.Condition)
+const (
+	ConditionAny         = "any"
+	ConditionEquals      = "eq"
+	ConditionNotEquals   = "ne"
+	ConditionGreaterThan = "gt"
+	ConditionLessThan    = "lt"
+)
+
+// triggers stores the MQTT subscriptions for tweet triggers (one per topic)
+// and when each stored tweet was last triggered and whether its condition matched last time,
+// keyed by the tweet's ID so renaming it doesn't reset them
+type triggers struct {
+	sync.Mutex
+	subscriptions map[string]*ninja.Subscription
+	lastSent      map[string]time.Time
+	matched       map[string]bool
+}
+
+// UpdateTriggers subscribes to the topics used by stored tweet triggers and cancels subscriptions no longer needed
+// It's called on start and whenever the stored tweets change
+func (a *TwitterApp) UpdateTriggers() {
+	a.triggers.Lock()
+	defer a.triggers.Unlock()
+
+	if a.triggers.subscriptions == nil {
+		a.triggers.subscriptions = make(map[string]*ninja.Subscription)
+		a.triggers.lastSent = make(map[string]time.Time)
+		a.triggers.matched = make(map[string]bool)
+	}
+
+	topics := make(map[string]bool)
+	for _, tweet := range a.Config().Tweets {
+		if tweet.Trigger != nil && tweet.Trigger.Topic != "" {
+			topics[tweet.Trigger.Topic] = true
+		}
+	}
+
+	for topic, subscription := range a.triggers.subscriptions {
+		if !topics[topic] {
+			log.Infof("Unsubscribing from trigger topic %s", topic)
+			subscription.Cancel()
+			delete(a.triggers.subscriptions, topic)
+		}
+	}
+
+	for topic := range topics {
+		if _, ok := a.triggers.subscriptions[topic]; ok {
+			continue
+		}
+		log.Infof("Subscribing to trigger topic %s", topic)
+		// copy topic for the closure
+		topic := topic
+		subscription, err := a.Conn.Subscribe(topic, func(params *json.RawMessage, values map[string]string) bool {
+			a.handleTriggerEvent(topic, params)
+			return true
+		})
+		if err != nil {
+			log.Errorf("Could not subscribe to trigger topic %s: %v", topic, err)
+			continue
+		}
+		a.triggers.subscriptions[topic] = subscription
+	}
+}
+
+// handleTriggerEvent sends each stored tweet whose trigger is for topic and whose condition has just become true,
+// unless it was sent by a trigger within its cooldown time
+func (a *TwitterApp) handleTriggerEvent(topic string, params *json.RawMessage) {
+	var payload interface{}
+	if params != nil {
+		if err := json.Unmarshal(*params, &payload); err != nil {
+			log.Errorf("Could not read event on trigger topic %s: %v", topic, err)
+			return
+		}
+	}
+
+	a.triggers.Lock()
+	defer a.triggers.Unlock()
+
+	for name, tweet := range a.Config().Tweets {
+		if tweet.Trigger == nil || tweet.Trigger.Topic != topic {
+			continue
+		}
+		// an event that can't be checked (e.g. it doesn't have the field) doesn't match
+		matched, err := tweet.Trigger.Matches(payload)
+		if err != nil {
+			log.Errorf("Could not check trigger for %s: %v", name, err)
+		}
+		// only send when the condition changes to true, so repeated state events don't send again
+		wasMatched := a.triggers.matched[tweet.ID]
+		a.triggers.matched[tweet.ID] = matched
+		if !matched || (wasMatched && tweet.Trigger.Condition != ConditionAny) {
+			continue
+		}
+
+		cooldown := time.Duration(tweet.Trigger.Cooldown) * time.Second
+		if cooldown == 0 {
+			cooldown = defaultTriggerCooldown
+		}
+		if time.Since(a.triggers.lastSent[tweet.ID]) < cooldown {
+			log.Infof("Trigger for %s matched but it was sent less than %v ago", name, cooldown)
+			continue
+		}
+		a.triggers.lastSent[tweet.ID] = time.Now()
+
+		log.Infof("Trigger for %s matched event on %s", name, topic)
+		go a.SendStoredTweet(name, payload)
+	}
+}
+
+// Matches checks an event payload against the trigger's condition
+// If the payload is a single value array it is unwrapped, and if Field is set the value is read from that key
+// (use dots for nested objects, e.g. "state.on"). An event without the field is an error, so it never matches
+func (t *TweetTrigger) Matches(payload interface{}) (bool, error) {
+	value, err := t.value(payload)
+	if err != nil {
+		return false, err
+	}
+
+	switch t.Condition {
+	case ConditionAny, "":
+		return true, nil
+	case ConditionEquals:
+		return payloadEquals(value, t.Value), nil
+	case ConditionNotEquals:
+		return !payloadEquals(value, t.Value), nil
+	case ConditionGreaterThan, ConditionLessThan:
+		number, err := strconv.ParseFloat(payloadString(value), 64)
+		if err != nil {
+			return false, fmt.Errorf("event value %v is not a number", value)
+		}
+		threshold, err := strconv.ParseFloat(t.Value, 64)
+		if err != nil {
+			return false, fmt.Errorf("trigger value %q is not a number", t.Value)
+		}
+		if t.Condition == ConditionGreaterThan {
+			return number > threshold, nil
+		}
+		return number < threshold, nil
+	default:
+		return false, fmt.Errorf("unknown condition %q", t.Condition)
+	}
+}
+
+// value returns the value in an event payload that the trigger checks
+func (t *TweetTrigger) value(payload interface{}) (interface{}, error) {
+	value := unwrapPayload(payload)
+	if t.Field != "" {
+		for _, key := range strings.Split(t.Field, ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("event has no field %q", t.Field)
+			}
+			if value, ok = object[key]; !ok {
+				return nil, fmt.Errorf("event has no field %q", t.Field)
+			}
+			value = unwrapPayload(value)
+		}
+	}
+	return value, nil
+}
+
+// unwrapPayload returns the only element of a single value array, or the value unchanged
+func unwrapPayload(value interface{}) interface{} {
+	if array, ok := value.([]interface{}); ok && len(array) == 1 {
+		return array[0]
+	}
+	return value
+}
+
+// payloadEquals compares a JSON value with a trigger value, as numbers if they both are (so 20 equals "20.0")
+func payloadEquals(value interface{}, triggerValue string) bool {
+	text := payloadString(value)
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		if threshold, err := strconv.ParseFloat(triggerValue, 64); err == nil {
+			return number == threshold
+		}
+	}
+	return text == triggerValue
+}
+
+// payloadString formats a JSON value for comparing with a trigger value (numbers without trailing zeros)
+func payloadString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
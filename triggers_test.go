@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTriggerMatches(t *testing.T) {
+	for _, test := range []struct {
+		trigger TweetTrigger
+		payload string
+		want    bool
+		wantErr bool
+	}{
+		{TweetTrigger{}, `{"on": true}`, true, false},
+		{TweetTrigger{Condition: ConditionAny}, `null`, true, false},
+		{TweetTrigger{Condition: ConditionEquals, Value: "true"}, `[true]`, true, false},
+		{TweetTrigger{Field: "on", Condition: ConditionEquals, Value: "true"}, `{"on": true}`, true, false},
+		{TweetTrigger{Field: "on", Condition: ConditionEquals, Value: "true"}, `[{"on": false}]`, false, false},
+		// dotted fields are nested objects (and single value arrays are unwrapped on the way)
+		{TweetTrigger{Field: "state.on", Condition: ConditionEquals, Value: "true"}, `{"state": {"on": true}}`, true, false},
+		{TweetTrigger{Field: "state.level", Condition: ConditionGreaterThan, Value: "0.5"}, `{"state": [{"level": 0.75}]}`, true, false},
+		{TweetTrigger{Field: "state.mode", Condition: ConditionNotEquals, Value: "away"}, `{"state": {"mode": "home"}}`, true, false},
+		// an event without the field doesn't match, whatever the condition
+		{TweetTrigger{Field: "on"}, `{"off": true}`, false, true},
+		{TweetTrigger{Field: "state.on", Condition: ConditionNotEquals, Value: "true"}, `{"state": {}}`, false, true},
+		{TweetTrigger{Field: "state.on", Condition: ConditionEquals, Value: ""}, `{"state": 5}`, false, true},
+		{TweetTrigger{Field: "on", Condition: ConditionNotEquals, Value: "x"}, `"on"`, false, true},
+		// numbers are compared as numbers, whether they're JSON numbers or strings
+		{TweetTrigger{Condition: ConditionEquals, Value: "20.0"}, `20`, true, false},
+		{TweetTrigger{Condition: ConditionEquals, Value: "20"}, `"20.00"`, true, false},
+		{TweetTrigger{Condition: ConditionNotEquals, Value: "20.0"}, `20`, false, false},
+		{TweetTrigger{Condition: ConditionEquals, Value: "20"}, `20.5`, false, false},
+		{TweetTrigger{Condition: ConditionGreaterThan, Value: "20"}, `21.5`, true, false},
+		{TweetTrigger{Condition: ConditionGreaterThan, Value: "20"}, `"21.5"`, true, false},
+		{TweetTrigger{Condition: ConditionGreaterThan, Value: "20"}, `20`, false, false},
+		{TweetTrigger{Condition: ConditionLessThan, Value: "9"}, `10`, false, false},
+		{TweetTrigger{Condition: ConditionLessThan, Value: "-5"}, `-10`, true, false},
+		// and strings as strings
+		{TweetTrigger{Condition: ConditionEquals, Value: "on"}, `"on"`, true, false},
+		{TweetTrigger{Condition: ConditionEquals, Value: "on"}, `"ON"`, false, false},
+		{TweetTrigger{Condition: ConditionEquals, Value: "1e3"}, `1000`, true, false},
+		{TweetTrigger{Condition: ConditionGreaterThan, Value: "20"}, `"hot"`, false, true},
+		{TweetTrigger{Condition: ConditionGreaterThan, Value: "warm"}, `25`, false, true},
+		{TweetTrigger{Condition: "between", Value: "1"}, `1`, false, true},
+	} {
+		var payload interface{}
+		if err := json.Unmarshal([]byte(test.payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		got, err := test.trigger.Matches(payload)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%+v matching %s is %v, %v, want %v (error %v)", test.trigger, test.payload, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// newTriggerApp returns an app with a stored tweet called "hello" triggered by trigger, ready to handle events
+func newTriggerApp(t *testing.T, trigger TweetTrigger) (*TwitterApp, *twitterServer) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello", Trigger: &trigger})
+	a.triggers.lastSent = make(map[string]time.Time)
+	a.triggers.matched = make(map[string]bool)
+	return a, server
+}
+
+// triggerEvent handles an event with payload on topic and returns whether it sent the tweet called name
+func triggerEvent(a *TwitterApp, topic, name, payload string) bool {
+	tweet, _ := a.StoredTweet(name)
+	a.triggers.Lock()
+	before := a.triggers.lastSent[tweet.ID]
+	a.triggers.Unlock()
+
+	params := json.RawMessage(payload)
+	a.handleTriggerEvent(topic, &params)
+
+	a.triggers.Lock()
+	defer a.triggers.Unlock()
+	return !a.triggers.lastSent[tweet.ID].Equal(before)
+}
+
+func TestTriggerSendsOnChange(t *testing.T) {
+	previous := defaultTriggerCooldown
+	defaultTriggerCooldown = 0
+	defer func() {
+		defaultTriggerCooldown = previous
+	}()
+	a, _ := newTriggerApp(t, TweetTrigger{Topic: "door", Field: "state.open", Condition: ConditionEquals, Value: "true"})
+
+	sent := 0
+	for i, event := range []struct {
+		payload string
+		want    bool
+	}{
+		{`{"state": {"open": true}}`, true},
+		// still open, so it isn't sent again
+		{`{"state": {"open": true}}`, false},
+		{`{"state": {"open": false}}`, false},
+		{`{"state": {"open": true}}`, true},
+		// an event without the field doesn't match, so the next one is a change
+		{`{"battery": 50}`, false},
+		{`{"state": {"open": true}}`, true},
+		{`{"state": {"open": true}}`, false},
+	} {
+		if got := triggerEvent(a, "door", "hello", event.payload); got != event.want {
+			t.Errorf("event %d %s sent the tweet: %v, want %v", i, event.payload, got, event.want)
+		}
+		if event.want {
+			sent++
+			waitForSent(t, a, "hello", sent)
+		}
+	}
+	// events on other topics are ignored
+	if triggerEvent(a, "window", "hello", `{"state": {"open": true}}`) {
+		t.Errorf("an event on another topic sent the tweet")
+	}
+}
+
+func TestTriggerAnySendsEveryEvent(t *testing.T) {
+	previous := defaultTriggerCooldown
+	defaultTriggerCooldown = 0
+	defer func() {
+		defaultTriggerCooldown = previous
+	}()
+	a, _ := newTriggerApp(t, TweetTrigger{Topic: "button", Condition: ConditionAny})
+
+	for i := 1; i <= 3; i++ {
+		if !triggerEvent(a, "button", "hello", `{"pressed": true}`) {
+			t.Errorf("event %d didn't send the tweet", i)
+		}
+		waitForSent(t, a, "hello", i)
+	}
+}
+
+func TestTriggerCooldownSurvivesRename(t *testing.T) {
+	a, server := newTriggerApp(t, TweetTrigger{Topic: "button", Condition: ConditionAny, Cooldown: 60})
+
+	if !triggerEvent(a, "button", "hello", `{}`) {
+		t.Fatalf("the first event didn't send the tweet")
+	}
+	waitForSent(t, a, "hello", 1)
+
+	tweet, _ := a.StoredTweet("hello")
+	tweet.Name = "greeting"
+	if err := a.updateConfig(func(m *TwitterAppModel) error { return m.saveTweet(tweet) }); err != nil {
+		t.Fatal(err)
+	}
+	if triggerEvent(a, "button", "greeting", `{}`) {
+		t.Errorf("renaming the tweet reset its cooldown")
+	}
+
+	// once the cooldown has passed it's sent again
+	a.triggers.Lock()
+	a.triggers.lastSent[tweet.ID] = time.Now().Add(-time.Minute)
+	a.triggers.Unlock()
+	if !triggerEvent(a, "button", "greeting", `{}`) {
+		t.Errorf("the event after the cooldown didn't send the tweet")
+	}
+	waitForSent(t, a, "greeting", 2)
+	if tweets := server.tweeted("@me"); len(tweets) != 2 {
+		t.Errorf("tweets are %q, want two", tweets)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// twitterTimeout is how long a request to Twitter can take, including reading the response
+var twitterTimeout = 30 * time.Second
+
+// errClientClosed is returned by a client that was closed (because its account's client was replaced or removed)
+var errClientClosed = errors.New("the Twitter client was closed")
+
+// TwitterClient is the part of the Twitter API the app uses, for one account
+type TwitterClient interface {
+	GetSelf() (anaconda.User, error)
+	PostTweet(status string, v url.Values) (anaconda.Tweet, error)
+	PostDM(text, screenName string) (anaconda.DirectMessage, error)
+	GetHomeTimeline(v url.Values) ([]anaconda.Tweet, error)
+	GetMentions(v url.Values) ([]anaconda.Tweet, error)
+	GetDirectMessages(v url.Values) ([]anaconda.DirectMessage, error)
+	UploadMedia(data string) (anaconda.Media, error)
+	DeleteTweet(id int64) (anaconda.Tweet, error)
+}
+
+// clientCloser is a TwitterClient that has to be closed when it's no longer used
+type clientCloser interface {
+	Close()
+}
+
+// closeClient closes client if it needs it
+func closeClient(client TwitterClient) {
+	if closer, ok := client.(clientCloser); ok {
+		closer.Close()
+	}
+}
+
+// NewTwitterClient creates the client for account that uses the real Twitter API
+func NewTwitterClient(account AccountDetails) TwitterClient {
+	return newAnacondaClient(account, nil)
+}
+
+// newAnacondaClient creates an anaconda client for account, sending its requests to base instead of Twitter
+// if it's set (e.g. a local stand-in of the REST API, see twitterclient_test.go)
+func newAnacondaClient(account AccountDetails, base *url.URL) *anacondaClient {
+	transport := &twitterTransport{base: base, headers: make(map[string]http.Header)}
+	api := anaconda.NewTwitterApi(account.AccessToken, account.AccessTokenSecret)
+	api.HttpClient = &http.Client{
+		Transport: transport,
+		Timeout:   twitterTimeout,
+		// the requests are signed for Twitter, so they aren't sent anywhere else
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &anacondaClient{
+		account:   account,
+		api:       api,
+		transport: transport,
+	}
+}
+
+// twitterTransport makes the requests for anaconda and keeps the header of the latest response from each endpoint
+// (for the rate limits). If base is set the requests go there instead of Twitter (keeping their paths).
+// signed is called when the next request has been signed (see anacondaClient.use)
+type twitterTransport struct {
+	sync.Mutex
+	base    *url.URL
+	headers map[string]http.Header
+	signed  func()
+}
+
+// RoundTrip sends request (or a copy of it to base) and keeps the response's header
+func (t *twitterTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.Lock()
+	signed := t.signed
+	t.signed = nil
+	t.Unlock()
+	if signed != nil {
+		signed()
+	}
+
+	if t.base != nil {
+		redirected := *request
+		u := *request.URL
+		u.Scheme, u.Host = t.base.Scheme, t.base.Host
+		redirected.URL = &u
+		redirected.Host = u.Host
+		request = &redirected
+	}
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err == nil {
+		t.Lock()
+		t.headers[endpointOf(request.URL.Path)] = response.Header
+		t.Unlock()
+	}
+	return response, err
+}
+
+// anacondaLock is held while anaconda signs a request because its consumer key and secret are global,
+// so they are set for the account each time an API is used. It's released once the request is signed
+// (when it reaches the transport), so it isn't held while waiting for Twitter
+var anacondaLock sync.Mutex
+
+// anacondaClient is a TwitterClient that uses the real Twitter API
+// calls is the number of calls in progress, the API is closed when the last one finishes after Close
+type anacondaClient struct {
+	sync.Mutex
+	account   AccountDetails
+	api       *anaconda.TwitterApi
+	transport *twitterTransport
+	calls     int
+	closed    bool
+}
+
+// RateLimitHeader returns the header of the latest response from endpoint (nil if there hasn't been one)
+func (c *anacondaClient) RateLimitHeader(endpoint string) http.Header {
+	c.transport.Lock()
+	defer c.transport.Unlock()
+	return c.transport.headers[endpoint]
+}
+
+// Close closes the anaconda API (stopping its goroutine) once the calls in progress have finished,
+// the client can't be used afterwards
+func (c *anacondaClient) Close() {
+	c.Lock()
+	defer c.Unlock()
+	if !c.closed && c.calls == 0 {
+		c.api.Close()
+	}
+	c.closed = true
+}
+
+// use sets the consumer key and secret for this client's account until its request has been signed,
+// returning the function to call when the call has finished, or errClientClosed if the client was closed
+func (c *anacondaClient) use() (func(), error) {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return nil, errClientClosed
+	}
+	c.calls++
+	c.Unlock()
+
+	anacondaLock.Lock()
+	anaconda.SetConsumerKey(c.account.ConsumerKey)
+	anaconda.SetConsumerSecret(c.account.ConsumerSecret)
+	var once sync.Once
+	release := func() {
+		once.Do(anacondaLock.Unlock)
+	}
+	c.transport.Lock()
+	c.transport.signed = release
+	c.transport.Unlock()
+
+	return func() {
+		// the request may not have been sent
+		release()
+		c.Lock()
+		defer c.Unlock()
+		c.calls--
+		if c.closed && c.calls == 0 {
+			c.api.Close()
+		}
+	}, nil
+}
+
+// GetSelf returns the account's user details (which checks that the authentication works)
+func (c *anacondaClient) GetSelf() (anaconda.User, error) {
+	done, err := c.use()
+	if err != nil {
+		return anaconda.User{}, err
+	}
+	defer done()
+	return c.api.GetSelf(nil)
+}
+
+// PostTweet posts a public tweet
+func (c *anacondaClient) PostTweet(status string, v url.Values) (anaconda.Tweet, error) {
+	done, err := c.use()
+	if err != nil {
+		return anaconda.Tweet{}, err
+	}
+	defer done()
+	return c.api.PostTweet(status, v)
+}
+
+// PostDM sends a direct message to the user with screenName
+func (c *anacondaClient) PostDM(text, screenName string) (anaconda.DirectMessage, error) {
+	done, err := c.use()
+	if err != nil {
+		return anaconda.DirectMessage{}, err
+	}
+	defer done()
+	return c.api.PostDMToScreenName(text, screenName)
+}
+
+// GetHomeTimeline returns the newest tweets from the account and the users it follows
+func (c *anacondaClient) GetHomeTimeline(v url.Values) ([]anaconda.Tweet, error) {
+	done, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return c.api.GetHomeTimeline(v)
+}
+
+// GetMentions returns the newest tweets mentioning the account
+func (c *anacondaClient) GetMentions(v url.Values) ([]anaconda.Tweet, error) {
+	done, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return c.api.GetMentionsTimeline(v)
+}
+
+// GetDirectMessages returns the newest direct messages sent to the account
+func (c *anacondaClient) GetDirectMessages(v url.Values) ([]anaconda.DirectMessage, error) {
+	done, err := c.use()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return c.api.GetDirectMessages(v)
+}
+
+// UploadMedia uploads a base64 encoded image, returning its media ID for attaching to a tweet
+func (c *anacondaClient) UploadMedia(data string) (anaconda.Media, error) {
+	done, err := c.use()
+	if err != nil {
+		return anaconda.Media{}, err
+	}
+	defer done()
+	return c.api.UploadMedia(data)
+}
+
+// DeleteTweet deletes one of the account's statuses
+func (c *anacondaClient) DeleteTweet(id int64) (anaconda.Tweet, error) {
+	done, err := c.use()
+	if err != nil {
+		return anaconda.Tweet{}, err
+	}
+	defer done()
+	return c.api.DeleteTweet(id, true)
+}
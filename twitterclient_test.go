@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// twitterServer is a stand-in for the parts of the Twitter REST API the app uses, which the clients from newClient
+// send requests to. Users are told apart by the access token in the OAuth header.
+// Like Twitter, a tweet or direct message that's the same as the user's last one is rejected as a duplicate.
+// Use fail to make an endpoint return an error, block to make its requests wait and limit to give it a rate limit
+type twitterServer struct {
+	*httptest.Server
+	sync.Mutex
+	base     *url.URL
+	users    map[string]string
+	tweets   map[string][]anaconda.Tweet
+	messages map[string][]anaconda.DirectMessage
+	mentions map[string][]anaconda.Tweet
+	received map[string][]anaconda.DirectMessage
+	failures map[string]serverFailure
+	blocked  map[string]chan bool
+	limits   map[string]int
+	used     map[string]int
+	requests map[string]int
+	nextID   int64
+}
+
+// serverFailure is the error response for an endpoint, header is added to it (e.g. for rate limits)
+type serverFailure struct {
+	status int
+	code   int
+	header http.Header
+}
+
+// oauthToken finds the access token in an OAuth Authorization header
+var oauthToken = regexp.MustCompile(`oauth_token="([^"]*)"`)
+
+// newTwitterServer starts a twitterServer, which is stopped when the test finishes
+func newTwitterServer(t *testing.T) *twitterServer {
+	s := &twitterServer{
+		users:    make(map[string]string),
+		tweets:   make(map[string][]anaconda.Tweet),
+		messages: make(map[string][]anaconda.DirectMessage),
+		mentions: make(map[string][]anaconda.Tweet),
+		received: make(map[string][]anaconda.DirectMessage),
+		failures: make(map[string]serverFailure),
+		blocked:  make(map[string]chan bool),
+		limits:   make(map[string]int),
+		used:     make(map[string]int),
+		requests: make(map[string]int),
+		nextID:   1,
+	}
+	s.Server = httptest.NewServer(s)
+	s.base, _ = url.Parse(s.URL)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newClient creates a client for account that sends its requests to the server
+func (s *twitterServer) newClient(account AccountDetails) TwitterClient {
+	return newAnacondaClient(account, s.base)
+}
+
+// addUser adds a Twitter user and returns an account with its access token
+func (s *twitterServer) addUser(username string) AccountDetails {
+	s.Lock()
+	defer s.Unlock()
+	token := "token-" + strings.TrimPrefix(username, "@")
+	s.users[token] = strings.TrimPrefix(username, "@")
+	return AccountDetails{
+		Username:          username,
+		ConsumerKey:       "key",
+		ConsumerSecret:    "secret",
+		AccessToken:       token,
+		AccessTokenSecret: "token-secret",
+	}
+}
+
+// fail makes endpoint (e.g. "statuses/update") return status with the Twitter error code until it's cleared
+func (s *twitterServer) fail(endpoint string, status, code int, header http.Header) {
+	s.Lock()
+	defer s.Unlock()
+	s.failures[endpoint] = serverFailure{status, code, header}
+}
+
+// clear stops endpoint failing
+func (s *twitterServer) clear(endpoint string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.failures, endpoint)
+}
+
+// block makes requests to endpoint wait until the returned function is called (calling it again does nothing)
+func (s *twitterServer) block(endpoint string) func() {
+	s.Lock()
+	defer s.Unlock()
+	release := make(chan bool)
+	s.blocked[endpoint] = release
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.Lock()
+			delete(s.blocked, endpoint)
+			s.Unlock()
+			close(release)
+		})
+	}
+}
+
+// limit gives each user requests to endpoint, which are sent in the x-rate-limit headers like Twitter does
+// (after that they're rejected)
+func (s *twitterServer) limit(endpoint string, requests int) {
+	s.Lock()
+	defer s.Unlock()
+	s.limits[endpoint] = requests
+}
+
+// tweeted returns the text of username's tweets, oldest first
+func (s *twitterServer) tweeted(username string) []string {
+	s.Lock()
+	defer s.Unlock()
+	var texts []string
+	for _, tweet := range s.tweets[strings.TrimPrefix(username, "@")] {
+		texts = append(texts, tweet.Text)
+	}
+	return texts
+}
+
+// sentMessages returns the text of username's direct messages, oldest first
+func (s *twitterServer) sentMessages(username string) []string {
+	s.Lock()
+	defer s.Unlock()
+	var texts []string
+	for _, message := range s.messages[strings.TrimPrefix(username, "@")] {
+		texts = append(texts, message.Text)
+	}
+	return texts
+}
+
+// requestCount returns the number of requests made to endpoint
+func (s *twitterServer) requestCount(endpoint string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests[endpoint]
+}
+
+// mention adds a tweet from user mentioning username
+func (s *twitterServer) mention(username, user, text string) {
+	s.Lock()
+	defer s.Unlock()
+	username = strings.TrimPrefix(username, "@")
+	s.mentions[username] = append(s.mentions[username], s.newTweet(strings.TrimPrefix(user, "@"), text))
+}
+
+// directMessage adds a direct message from user to username
+func (s *twitterServer) directMessage(username, user, text string) {
+	s.Lock()
+	defer s.Unlock()
+	username = strings.TrimPrefix(username, "@")
+	s.received[username] = append(s.received[username], s.newMessage(strings.TrimPrefix(user, "@"), username, text))
+}
+
+// newTweet makes a tweet with the next ID (lock must be held)
+func (s *twitterServer) newTweet(user, text string) anaconda.Tweet {
+	tweet := anaconda.Tweet{
+		Id:    s.nextID,
+		IdStr: strconv.FormatInt(s.nextID, 10),
+		Text:  text,
+		User:  anaconda.User{ScreenName: user},
+	}
+	s.nextID++
+	return tweet
+}
+
+// newMessage makes a direct message with the next ID (lock must be held)
+func (s *twitterServer) newMessage(from, to, text string) anaconda.DirectMessage {
+	message := anaconda.DirectMessage{
+		Id:                  s.nextID,
+		IdStr:               strconv.FormatInt(s.nextID, 10),
+		Text:                text,
+		SenderScreenName:    from,
+		RecipientScreenName: to,
+		Sender:              anaconda.User{ScreenName: from},
+	}
+	s.nextID++
+	return message
+}
+
+// ServeHTTP answers the API requests for each endpoint (see endpointOf)
+func (s *twitterServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	endpoint := endpointOf(r.URL.Path)
+	id := strings.TrimSuffix(path.Base(r.URL.Path), ".json")
+
+	s.Lock()
+	s.requests[endpoint]++
+	release := s.blocked[endpoint]
+	s.Unlock()
+	if release != nil {
+		<-release
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	token := ""
+	if match := oauthToken.FindStringSubmatch(r.Header.Get("Authorization")); match != nil {
+		token, _ = url.QueryUnescape(match[1])
+	}
+	user, ok := s.users[token]
+	if !ok {
+		writeTwitterError(w, http.StatusUnauthorized, 89, "Invalid or expired token.")
+		return
+	}
+	if limit, ok := s.limits[endpoint]; ok {
+		s.used[user+" "+endpoint]++
+		remaining := limit - s.used[user+" "+endpoint]
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("X-Rate-Limit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Rate-Limit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Add(15*time.Minute).Unix(), 10))
+		if s.used[user+" "+endpoint] > limit {
+			writeTwitterError(w, http.StatusTooManyRequests, anaconda.TwitterErrorRateLimitExceeded, "Rate limit exceeded.")
+			return
+		}
+	}
+	if failure, ok := s.failures[endpoint]; ok {
+		for key, values := range failure.header {
+			w.Header()[key] = values
+		}
+		writeTwitterError(w, failure.status, failure.code, "Failed for testing.")
+		return
+	}
+
+	switch endpoint {
+	case "account/verify_credentials":
+		writeJSON(w, anaconda.User{ScreenName: user})
+	case "statuses/update":
+		status := r.Form.Get("status")
+		tweets := s.tweets[user]
+		if len(tweets) > 0 && tweets[len(tweets)-1].Text == status {
+			writeTwitterError(w, http.StatusForbidden, anaconda.TwitterErrorStatusIsADuplicate, "Status is a duplicate.")
+			return
+		}
+		tweet := s.newTweet(user, status)
+		s.tweets[user] = append(tweets, tweet)
+		writeJSON(w, tweet)
+	case EndpointDelete:
+		for i, tweet := range s.tweets[user] {
+			if tweet.IdStr == id {
+				s.tweets[user] = append(s.tweets[user][:i], s.tweets[user][i+1:]...)
+				writeJSON(w, tweet)
+				return
+			}
+		}
+		writeTwitterError(w, http.StatusNotFound, 144, "No status found with that ID.")
+	case "direct_messages/new":
+		text := r.Form.Get("text")
+		messages := s.messages[user]
+		if len(messages) > 0 && messages[len(messages)-1].Text == text {
+			writeTwitterError(w, http.StatusForbidden, anaconda.TwitterErrorStatusIsADuplicate, "Status is a duplicate.")
+			return
+		}
+		message := s.newMessage(user, strings.TrimPrefix(r.Form.Get("screen_name"), "@"), text)
+		s.messages[user] = append(messages, message)
+		writeJSON(w, message)
+	case "statuses/home_timeline":
+		writeJSON(w, newestTweets(s.tweets[user], r.Form))
+	case "statuses/mentions_timeline":
+		writeJSON(w, newestTweets(s.mentions[user], r.Form))
+	case "direct_messages":
+		sinceID, count := pageValues(r.Form)
+		messages := []anaconda.DirectMessage{}
+		received := s.received[user]
+		for i := len(received) - 1; i >= 0 && len(messages) < count; i-- {
+			if received[i].Id > sinceID {
+				messages = append(messages, received[i])
+			}
+		}
+		writeJSON(w, messages)
+	case "media/upload":
+		if _, err := base64.StdEncoding.DecodeString(r.Form.Get("media_data")); err != nil || r.Form.Get("media_data") == "" {
+			writeTwitterError(w, http.StatusBadRequest, 324, "Invalid media.")
+			return
+		}
+		writeJSON(w, anaconda.Media{MediaID: s.nextID, MediaIDString: strconv.FormatInt(s.nextID, 10)})
+		s.nextID++
+	default:
+		writeTwitterError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+	}
+}
+
+// writeJSON writes value as the JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeTwitterError writes an error response like Twitter's
+func writeTwitterError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anaconda.TwitterErrorResponse{
+		Errors: []anaconda.TwitterError{{Code: code, Message: message}},
+	})
+}
+
+func TestClientUsesBaseURL(t *testing.T) {
+	server := newTwitterServer(t)
+	client := server.newClient(server.addUser("@me"))
+
+	user, err := client.GetSelf()
+	if err != nil || user.ScreenName != "me" {
+		t.Fatalf("GetSelf returned %v, %v", user, err)
+	}
+	tweet, err := client.PostTweet("Hello", nil)
+	if err != nil || tweet.Text != "Hello" {
+		t.Fatalf("PostTweet returned %v, %v", tweet, err)
+	}
+	if _, err := client.PostTweet("Hello", nil); !IsDuplicate(err) || IsTransient(err) {
+		t.Errorf("posting the same tweet again returned %v, want a duplicate error", err)
+	}
+	// uploads go to upload.twitter.com, which is redirected too
+	media, err := client.UploadMedia(base64.StdEncoding.EncodeToString([]byte("image")))
+	if err != nil || media.MediaIDString == "" {
+		t.Errorf("UploadMedia returned %v, %v", media, err)
+	}
+	if _, err := client.DeleteTweet(tweet.Id); err != nil {
+		t.Errorf("DeleteTweet returned %v", err)
+	}
+	if tweets := server.tweeted("@me"); len(tweets) != 0 {
+		t.Errorf("tweets after deleting are %q, want none", tweets)
+	}
+	if _, err := client.PostDM("Hi", "@you"); err != nil {
+		t.Errorf("PostDM returned %v", err)
+	}
+
+	server.mention("@me", "@you", "@me hi")
+	server.directMessage("@me", "@you", "status")
+	if mentions, err := client.GetMentions(nil); err != nil || len(mentions) != 1 || mentions[0].User.ScreenName != "you" {
+		t.Errorf("GetMentions returned %v, %v", mentions, err)
+	}
+	if messages, err := client.GetDirectMessages(nil); err != nil || len(messages) != 1 || messages[0].Text != "status" {
+		t.Errorf("GetDirectMessages returned %v, %v", messages, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newTwitterServer(t)
+	account := server.addUser("@me")
+
+	wrong := account
+	wrong.AccessToken = "wrong"
+	if _, err := server.newClient(wrong).GetSelf(); err == nil || IsTransient(err) {
+		t.Errorf("GetSelf with the wrong token returned %v, want a permanent error", err)
+	}
+
+	client := server.newClient(account)
+	server.fail("statuses/update", http.StatusServiceUnavailable, 130, nil)
+	if _, err := client.PostTweet("Hello", nil); err == nil || !IsTransient(err) {
+		t.Errorf("PostTweet while Twitter is over capacity returned %v, want a transient error", err)
+	}
+	server.clear("statuses/update")
+	if _, err := client.PostTweet("Hello", nil); err != nil {
+		t.Errorf("PostTweet after clearing the failure returned %v", err)
+	}
+}
+
+func TestClientNotLockedWhileWaiting(t *testing.T) {
+	server := newTwitterServer(t)
+	me := server.newClient(server.addUser("@me"))
+	you := server.newClient(server.addUser("@you"))
+
+	release := server.block(EndpointTweet)
+	defer release()
+	posted := make(chan error)
+	go func() {
+		_, err := me.PostTweet("Hello", nil)
+		posted <- err
+	}()
+	for server.requestCount(EndpointTweet) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// another account (and the same one) can use the API while Twitter hasn't answered
+	done := make(chan bool)
+	go func() {
+		if _, err := you.GetSelf(); err != nil {
+			t.Errorf("GetSelf returned %v", err)
+		}
+		if _, err := me.GetSelf(); err != nil {
+			t.Errorf("GetSelf returned %v", err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("using the API waited for another request's response")
+	}
+	release()
+	if err := <-posted; err != nil {
+		t.Errorf("PostTweet returned %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	server := newTwitterServer(t)
+	previous := twitterTimeout
+	twitterTimeout = 50 * time.Millisecond
+	defer func() {
+		twitterTimeout = previous
+	}()
+	client := server.newClient(server.addUser("@me"))
+
+	release := server.block(EndpointTweet)
+	defer release()
+	if _, err := client.PostTweet("Hello", nil); err == nil || !IsTransient(err) {
+		t.Errorf("PostTweet without a response returned %v, want a transient error", err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	server := newTwitterServer(t)
+	client := server.newClient(server.addUser("@me")).(*anacondaClient)
+
+	release := server.block(EndpointTweet)
+	defer release()
+	posted := make(chan error)
+	go func() {
+		_, err := client.PostTweet("Hello", nil)
+		posted <- err
+	}()
+	for server.requestCount(EndpointTweet) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// the call in progress finishes before the API is closed
+	client.Close()
+	release()
+	if err := <-posted; err != nil {
+		t.Errorf("PostTweet that started before closing returned %v", err)
+	}
+	if _, err := client.GetSelf(); err != errClientClosed || !IsTransient(err) {
+		t.Errorf("GetSelf after closing returned %v, want %v", err, errClientClosed)
+	}
+}
+
+func TestInitClosesClients(t *testing.T) {
+	server := newTwitterServer(t)
+	var clients []*anacondaClient
+	a := newTestApp(t, server)
+	a.newClient = func(account AccountDetails) TwitterClient {
+		client := newAnacondaClient(account, server.base)
+		clients = append(clients, client)
+		return client
+	}
+	account, _ := a.Account("@me")
+
+	a.InitTwitterAPI(account)
+	server.fail(EndpointVerify, http.StatusServiceUnavailable, 130, nil)
+	a.InitTwitterAPI(account)
+	server.clear(EndpointVerify)
+	a.InitTwitterAPI(account)
+	if len(clients) != 3 || !clients[0].closed || !clients[1].closed || clients[2].closed {
+		t.Fatalf("after replacing a client and one failing, %d clients were made", len(clients))
+	}
+	a.DeleteAccount("@me")
+	if !clients[2].closed {
+		t.Errorf("the client for a deleted account wasn't closed")
+	}
+}
@@ -1,19 +1,88 @@
 package main
 
-// TwitterAppModel stores the details for an account and the stored tweets
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TwitterAppModel stores the details for the accounts and the stored tweets
+// Version is the config's schema version (see migrations), 0 for configs saved before it was added
+// Accounts are keyed by username (e.g. "@someone"). Account is the old single account, only kept for loading older configs
+// Pending is the queue of messages waiting to be retried
+// Display has the settings for the LED matrix, Timeline has the settings for getting mentions, direct messages and tweets
+// Commands has the settings for controlling the Sphere with direct messages
+// Gestures maps gestures (Gesture constants) to what they do (Action constants), missing ones use the defaults
+// Groups are the names of the tweet groups in the order they're chosen on the spheramid
 type TwitterAppModel struct {
-	Account    AccountDetails          `json:"account"`
-	Tweets     map[string]TweetDetails `json:"tweets"`
-	TweetNames []string                `json:"tweetnames"`
+	Version        int                       `json:"version"`
+	Accounts       map[string]AccountDetails `json:"accounts"`
+	DefaultAccount string                    `json:"defaultaccount"`
+	Account        *AccountDetails           `json:"account,omitempty"`
+	Tweets         map[string]TweetDetails   `json:"tweets"`
+	TweetNames     []string                  `json:"tweetnames"`
+	Pending        []QueuedMessage           `json:"pending"`
+	Display        DisplaySettings           `json:"display"`
+	Timeline       TimelineSettings          `json:"timeline"`
+	Commands       CommandSettings           `json:"commands"`
+	Gestures       map[string]string         `json:"gestures"`
+	Groups         []string                  `json:"groups"`
+}
+
+// DisplaySettings stores the options for scrolling text on the LED matrix
+// ScrollNames scrolls the stored tweet's name when it's chosen, ScrollMessages scrolls the message before sending it
+// ScrollSpeed is milliseconds per pixel (0 for the default), ScrollColour is like "#FFA500" (blank for the default)
+// ConfirmSend needs a tap within ConfirmTime seconds after double tapping to send a tweet
+// UndoSend allows a tap within UndoTime seconds after sending a public tweet to delete it (0 times for the defaults)
+type DisplaySettings struct {
+	ScrollNames    bool   `json:"scrollnames"`
+	ScrollMessages bool   `json:"scrollmessages"`
+	ScrollSpeed    int    `json:"scrollspeed,string"`
+	ScrollColour   string `json:"scrollcolour"`
+	ConfirmSend    bool   `json:"confirmsend"`
+	ConfirmTime    int    `json:"confirmtime,string"`
+	UndoSend       bool   `json:"undosend"`
+	UndoTime       int    `json:"undotime,string"`
 }
 
 // TweetDetails stores the values for one tweet or direct message
-// Number is the auto-incrementing value to add to tweets/messages so that Twitter won't reject as duplicates
+// ID stays the same when the tweet is renamed, so it's used to find the tweet being edited
+// Number counts how many times it has been sent (it can be reset) and is {{.Count}} in templates, LastSent is when it last worked
+// Unique is how it's kept different each time so Twitter won't reject it as a duplicate (Unique constants, blank for the default)
+// Variation counts the variations that have been used, Alternatives are the other messages for UniqueAlternatives
+// Account is the username to send from, blank means the default account
+// Trigger is optional, for sending the tweet automatically when a device event happens
+// Schedule is optional, for sending the tweet automatically at a time or regularly
+// Media is an optional image file path or URL, or "snapshot" for a picture of the LED matrix, to attach to public tweets
+// Group is the name of the group the tweet is in, blank for none
 type TweetDetails struct {
-	Name    string `json:"name"`
-	Message string `json:"message"`
-	To      string `json:"to"`
-	Number  int    `json:"number,string"`
+	ID           string         `json:"id"`
+	Name         string         `json:"name"`
+	Message      string         `json:"message"`
+	To           string         `json:"to"`
+	Account      string         `json:"account"`
+	Number       int            `json:"number,string"`
+	LastSent     time.Time      `json:"lastsent"`
+	Unique       string         `json:"unique"`
+	Variation    int            `json:"variation,string"`
+	Alternatives []string       `json:"alternatives"`
+	Media        string         `json:"media"`
+	Group        string         `json:"group"`
+	Trigger      *TweetTrigger  `json:"trigger,omitempty"`
+	Schedule     *TweetSchedule `json:"schedule,omitempty"`
+}
+
+// TweetTrigger sends a stored tweet when an event on the MQTT Topic matches the condition
+// (e.g. "$device/<id>/channel/<channel>/event/state")
+// Field is an optional key for reading the value from object payloads, Condition is one of the Condition constants,
+// Value is what the event value is compared to, Cooldown is the minimum seconds between sends (0 for the default)
+type TweetTrigger struct {
+	Topic     string `json:"topic"`
+	Field     string `json:"field"`
+	Condition string `json:"condition"`
+	Value     string `json:"value"`
+	Cooldown  int    `json:"cooldown,string"`
 }
 
 // AccountDetails stores the authentication details for one user
@@ -25,3 +94,217 @@ type AccountDetails struct {
 	AccessToken       string `json:"accesstoken"`
 	AccessTokenSecret string `json:"accesstokensecret"`
 }
+
+// TweetSchedule sends a stored tweet once at a time (At, formatted like "2015-07-31 07:00")
+// and/or regularly with a cron spec (e.g. "0 7 * * 1-5" for 7am on weekdays)
+// Timezone is a name like "Australia/Brisbane", blank for the Sphere's local time
+type TweetSchedule struct {
+	At       string `json:"at"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+}
+
+// TimelineSettings stores what to poll Twitter for and how often (PollInterval in seconds, 0 for the default)
+type TimelineSettings struct {
+	Enabled        bool `json:"enabled"`
+	Mentions       bool `json:"mentions"`
+	DirectMessages bool `json:"directmessages"`
+	Home           bool `json:"home"`
+	PollInterval   int  `json:"pollinterval,string"`
+}
+
+// CommandSettings stores who can control the Sphere with direct messages (AllowList has Twitter handles)
+// LastIDs are the newest direct message IDs checked for commands for each account, only newer ones are run
+// (cleared when commands are turned on, so messages sent before that are never run)
+type CommandSettings struct {
+	Enabled   bool             `json:"enabled"`
+	AllowList []string         `json:"allowlist"`
+	LastIDs   map[string]int64 `json:"lastids"`
+}
+
+// Copy returns a copy of the config that doesn't share any maps or slices with it
+func (m *TwitterAppModel) Copy() TwitterAppModel {
+	c := *m
+	c.Accounts = make(map[string]AccountDetails, len(m.Accounts))
+	for username, account := range m.Accounts {
+		c.Accounts[username] = account
+	}
+	if m.Account != nil {
+		account := *m.Account
+		c.Account = &account
+	}
+	c.Tweets = make(map[string]TweetDetails, len(m.Tweets))
+	for name, tweet := range m.Tweets {
+		c.Tweets[name] = tweet.Copy()
+	}
+	c.TweetNames = append([]string(nil), m.TweetNames...)
+	c.Pending = append([]QueuedMessage(nil), m.Pending...)
+	c.Commands.AllowList = append([]string(nil), m.Commands.AllowList...)
+	c.Commands.LastIDs = make(map[string]int64, len(m.Commands.LastIDs))
+	for username, id := range m.Commands.LastIDs {
+		c.Commands.LastIDs[username] = id
+	}
+	c.Gestures = make(map[string]string, len(m.Gestures))
+	for gesture, action := range m.Gestures {
+		c.Gestures[gesture] = action
+	}
+	c.Groups = append([]string(nil), m.Groups...)
+	return c
+}
+
+// Copy returns a copy of the tweet that doesn't share its trigger, schedule or alternatives with it
+func (t TweetDetails) Copy() TweetDetails {
+	c := t
+	c.Alternatives = append([]string(nil), t.Alternatives...)
+	if t.Trigger != nil {
+		trigger := *t.Trigger
+		c.Trigger = &trigger
+	}
+	if t.Schedule != nil {
+		schedule := *t.Schedule
+		c.Schedule = &schedule
+	}
+	return c
+}
+
+// AccountNames returns the usernames of all accounts in alphabetical order
+func (m *TwitterAppModel) AccountNames() []string {
+	names := make([]string, 0, len(m.Accounts))
+	for username := range m.Accounts {
+		names = append(names, username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AccountFor returns the username that tweet should be sent from - its own account if that exists, or the default
+func (m *TwitterAppModel) AccountFor(tweet TweetDetails) string {
+	if _, ok := m.Accounts[tweet.Account]; ok {
+		return tweet.Account
+	}
+	return m.DefaultAccount
+}
+
+// removeAccount deletes an account and moves its tweets (and default status) to replacement
+// if replacement is blank, tweets use the default account and the first remaining account becomes the default
+func (m *TwitterAppModel) removeAccount(username, replacement string) {
+	delete(m.Accounts, username)
+	delete(m.Commands.LastIDs, username)
+	for name, tweet := range m.Tweets {
+		if tweet.Account == username {
+			tweet.Account = replacement
+			m.Tweets[name] = tweet
+		}
+	}
+	if m.DefaultAccount == username {
+		m.DefaultAccount = replacement
+		if replacement == "" && len(m.Accounts) > 0 {
+			m.DefaultAccount = m.AccountNames()[0]
+		}
+	}
+}
+
+// tweetNameByID returns the name of the stored tweet with id (blank if there isn't one)
+func (m *TwitterAppModel) tweetNameByID(id string) string {
+	if id == "" {
+		return ""
+	}
+	for name, tweet := range m.Tweets {
+		if tweet.ID == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// assignTweetIDs gives an ID to the stored tweets saved before tweets had them, returns whether any were changed
+func (m *TwitterAppModel) assignTweetIDs() bool {
+	changed := false
+	for _, name := range m.TweetNames {
+		tweet, ok := m.Tweets[name]
+		if !ok || tweet.ID != "" {
+			continue
+		}
+		tweet.ID = m.newTweetID()
+		m.Tweets[name] = tweet
+		changed = true
+	}
+	return changed
+}
+
+// saveTweet adds a new stored tweet (at the end) or replaces the one with the same ID in place,
+// renaming it if its name has changed. It returns an error if another tweet already has the name
+// or its message could be too long
+func (m *TwitterAppModel) saveTweet(tweet TweetDetails) error {
+	if tweet.Name == "" {
+		return fmt.Errorf("the tweet needs a name")
+	}
+	if existing, ok := m.Tweets[tweet.Name]; ok && (tweet.ID == "" || existing.ID != tweet.ID) {
+		return fmt.Errorf("there is already a tweet called %s", tweet.Name)
+	}
+	if m.Tweets == nil {
+		m.Tweets = make(map[string]TweetDetails)
+	}
+
+	previous := m.tweetNameByID(tweet.ID)
+	if existing, ok := m.Tweets[previous]; ok {
+		// the counts are kept, they may have changed while it was being edited
+		tweet.Number = existing.Number
+		tweet.LastSent = existing.LastSent
+		tweet.Variation = existing.Variation
+	}
+	if err := checkLength(tweet); err != nil {
+		return err
+	}
+	if previous == "" {
+		if tweet.ID == "" {
+			tweet.ID = m.newTweetID()
+		}
+		m.TweetNames = append(m.TweetNames, tweet.Name)
+	} else if previous != tweet.Name {
+		// rename, keeping its place in the order
+		delete(m.Tweets, previous)
+		if i := indexOf(m.TweetNames, previous); i >= 0 {
+			m.TweetNames[i] = tweet.Name
+		} else {
+			m.TweetNames = append(m.TweetNames, tweet.Name)
+		}
+	}
+	m.Tweets[tweet.Name] = tweet
+	return nil
+}
+
+// splitTweetCounters starts the variation counter of tweets saved before it was separate from the count
+// at their count (so the same numbers aren't used again), returns whether any were changed
+func (m *TwitterAppModel) splitTweetCounters() bool {
+	changed := false
+	for name, tweet := range m.Tweets {
+		if tweet.Variation == 0 && tweet.Number > 0 {
+			tweet.Variation = tweet.Number
+			m.Tweets[name] = tweet
+			changed = true
+		}
+	}
+	return changed
+}
+
+// moveTweet swaps the stored tweet called name with the one direction places from it (-1 for up, 1 for down),
+// returns false if it can't move that way
+func (m *TwitterAppModel) moveTweet(name string, direction int) bool {
+	i := indexOf(m.TweetNames, name)
+	j := i + direction
+	if i < 0 || j < 0 || j >= len(m.TweetNames) {
+		return false
+	}
+	m.TweetNames[i], m.TweetNames[j] = m.TweetNames[j], m.TweetNames[i]
+	return true
+}
+
+// newTweetID returns an ID for a stored tweet that no other tweet has
+func (m *TwitterAppModel) newTweetID() string {
+	id := time.Now().UnixNano()
+	for m.tweetNameByID(strconv.FormatInt(id, 10)) != "" {
+		id++
+	}
+	return strconv.FormatInt(id, 10)
+}
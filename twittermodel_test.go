@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	m := &TwitterAppModel{Tweets: map[string]TweetDetails{
+		"hello": {
+			Name:         "hello",
+			Alternatives: []string{"Hi"},
+			Trigger:      &TweetTrigger{Topic: "topic"},
+			Schedule:     &TweetSchedule{Cron: "0 7 * * *"},
+		},
+	}}
+	c := m.Copy()
+	copied := c.Tweets["hello"]
+	copied.Alternatives[0] = "Changed"
+	copied.Trigger.Topic = "changed"
+	copied.Schedule.Cron = "changed"
+
+	hello := m.Tweets["hello"]
+	if hello.Alternatives[0] != "Hi" || hello.Trigger.Topic != "topic" || hello.Schedule.Cron != "0 7 * * *" {
+		t.Errorf("changing the copy changed the config's tweet to %+v", hello)
+	}
+}
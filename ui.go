@@ -1,11 +1,13 @@
 package main
 
-// TODO - (if useful) make config handle multiple accounts... would require multiple APIs (or switching between)... (just using one for now)
-// TODO - make option for resetting number (rename to tweet count)
-
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ninjasphere/go-ninja/model"
 	"github.com/ninjasphere/go-ninja/suit"
@@ -32,47 +34,111 @@ func (c *ConfigService) Configure(request *model.ConfigurationRequest) (*suit.Co
 
 	switch request.Action {
 	case "":
-		if c.app.config.Account.Username != "" {
+		if len(c.app.AccountNames()) > 0 {
 			return c.listTweets()
 		}
 		fallthrough
 	case "listAccounts":
-		// present the existing or new Twitter Account screen
-		if c.app.config.Account.Username != "" {
+		// present the existing accounts or new Twitter Account screen
+		if len(c.app.AccountNames()) > 0 {
 			return c.listAccounts()
 		}
 		fallthrough
 	case "newAccount":
-		return c.editAccount(&TwitterAppModel{})
+		return c.editAccount(AccountDetails{})
 
 	case "editAccount":
-		return c.editAccount(c.app.config)
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal edit account config request %s: %s", request.Data, err))
+		}
+		account, ok := c.app.Account(values["account"])
+		if !ok {
+			return c.error(fmt.Sprintf("Could not find account %s", values["account"]))
+		}
+		return c.editAccount(account)
 
 	case "saveAccount":
-		configData := &AccountDetails{}
-		err := json.Unmarshal(request.Data, configData)
+		// the account details plus the username before editing and whether to make it the default
+		var configData struct {
+			AccountDetails
+			Previous string `json:"previous"`
+			Default  bool   `json:"default"`
+		}
+		err := json.Unmarshal(request.Data, &configData)
 		if err != nil {
 			return c.error(fmt.Sprintf("Failed to unmarshal save config request %s: %s", request.Data, err))
 		}
 
 		// check and add @ if needed
-		if len(configData.Username) > 0 && configData.Username[0] != '@' {
-			configData.Username = "@" + configData.Username
+		configData.Username = addAt(configData.Username)
+		if configData.Username == "" {
+			return c.error("Username is required")
+		}
+		// the secrets aren't shown, so blank means keep the existing ones
+		if existing, ok := c.app.Account(configData.Previous); ok {
+			if configData.ConsumerSecret == "" {
+				configData.ConsumerSecret = existing.ConsumerSecret
+			}
+			if configData.AccessTokenSecret == "" {
+				configData.AccessTokenSecret = existing.AccessTokenSecret
+			}
 		}
-		err = c.app.SaveAccount(*configData)
+		err = c.app.SaveAccount(configData.AccountDetails, configData.Previous, configData.Default)
 		if err != nil {
 			return c.error(fmt.Sprintf("Could not save Twitter Account: %s", err))
 		}
 
 		return c.listAccounts()
 
+	case "signIn":
+		// skip asking for the consumer key and secret if there are defaults
+		if defaultConsumerKey != "" && defaultConsumerSecret != "" {
+			return c.startSignIn(defaultConsumerKey, defaultConsumerSecret)
+		}
+		return c.signIn()
+
+	case "startSignIn":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal start sign in config request %s: %s", request.Data, err))
+		}
+		return c.startSignIn(values["consumerkey"], values["consumersecret"])
+
+	case "finishSignIn":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal finish sign in config request %s: %s", request.Data, err))
+		}
+		account, err := c.app.FinishSignIn(values["token"], strings.TrimSpace(values["pin"]))
+		if err != nil {
+			// the PIN can be entered again unless the sign in has expired
+			if authURL, ok := c.app.SignInURL(values["token"]); ok {
+				return c.enterPIN(authURL, values["token"], err.Error())
+			}
+			return c.error(err.Error())
+		}
+		// signing in to an account that's already here again replaces its tokens
+		previous := ""
+		if _, ok := c.app.Account(account.Username); ok {
+			previous = account.Username
+		}
+		err = c.app.SaveAccount(account, previous, false)
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not save Twitter Account: %s", err))
+		}
+		return c.listAccounts()
+
 	case "confirmDelete":
 		var values map[string]string
 		err := json.Unmarshal(request.Data, &values)
 		if err != nil {
 			return c.error(fmt.Sprintf("Failed to unmarshal confirm delete config request %s: %s", request.Data, err))
 		}
-		return c.confirmDeleteAccount(values["username"])
+		return c.confirmDeleteAccount(values["account"])
 
 	case "delete":
 		var values map[string]string
@@ -80,10 +146,12 @@ func (c *ConfigService) Configure(request *model.ConfigurationRequest) (*suit.Co
 		if err != nil {
 			return c.error(fmt.Sprintf("Failed to unmarshal delete config request %s: %s", request.Data, err))
 		}
-		// set username to blank, save config, load new account screen
-		c.app.config.Account.Username = ""
-		c.app.SendEvent("config", c.app.config)
-		return c.editAccount(&TwitterAppModel{})
+		// remove account, save config, load accounts screen (or new account screen if none are left)
+		c.app.DeleteAccount(values["username"])
+		if len(c.app.AccountNames()) == 0 {
+			return c.editAccount(AccountDetails{})
+		}
+		return c.listAccounts()
 
 	case "confirmDeleteTweet":
 		var values map[string]string
@@ -99,20 +167,285 @@ func (c *ConfigService) Configure(request *model.ConfigurationRequest) (*suit.Co
 		if err != nil {
 			return c.error(fmt.Sprintf("Failed to unmarshal delete tweet config request %s: %s", request.Data, err))
 		}
-		// remove tweet from map and slice, save config
-		delete(c.app.config.Tweets, values["tweetName"])
+		// remove tweet from map and slice, save config (unless it had already gone)
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			if !m.deleteTweet(values["tweetName"]) {
+				return fmt.Errorf("There is no stored tweet called %s", values["tweetName"])
+			}
+			return nil
+		})
+		c.app.UpdateTriggers()
+		c.app.UpdateSchedules()
+		return c.listTweets()
+
+	case "listTweets":
+		return c.listTweets()
+
+	case "listSchedules":
+		return c.listSchedules()
+
+	case "editDisplay":
+		return c.editDisplay()
+
+	case "saveDisplay":
+		// speed and times are read as strings since they can be blank
+		var values struct {
+			DisplaySettings
+			ScrollSpeed string `json:"scrollspeed"`
+			ConfirmTime string `json:"confirmtime"`
+			UndoTime    string `json:"undotime"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal save display config request %s: %s", request.Data, err))
+		}
+		values.DisplaySettings.ScrollSpeed = 0
+		if values.ScrollSpeed != "" {
+			values.DisplaySettings.ScrollSpeed, err = strconv.Atoi(values.ScrollSpeed)
+			if err != nil || values.DisplaySettings.ScrollSpeed <= 0 {
+				return c.error(fmt.Sprintf("Speed must be a number of milliseconds, not %s", values.ScrollSpeed))
+			}
+		}
+		values.DisplaySettings.ConfirmTime = 0
+		if values.ConfirmTime != "" {
+			values.DisplaySettings.ConfirmTime, err = strconv.Atoi(values.ConfirmTime)
+			if err != nil || values.DisplaySettings.ConfirmTime <= 0 {
+				return c.error(fmt.Sprintf("Confirm time must be a number of seconds, not %s", values.ConfirmTime))
+			}
+		}
+		values.DisplaySettings.UndoTime = 0
+		if values.UndoTime != "" {
+			values.DisplaySettings.UndoTime, err = strconv.Atoi(values.UndoTime)
+			if err != nil || values.DisplaySettings.UndoTime <= 0 {
+				return c.error(fmt.Sprintf("Undo time must be a number of seconds, not %s", values.UndoTime))
+			}
+		}
+		if err := values.DisplaySettings.check(); err != nil {
+			return c.error(err.Error())
+		}
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			m.Display = values.DisplaySettings
+			return nil
+		})
+		return c.listTweets()
+
+	case "editGestures":
+		return c.editGestures()
+
+	case "saveGestures":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal save gestures config request %s: %s", request.Data, err))
+		}
+		gestures := make(map[string]string)
+		for _, gesture := range gestureNames {
+			if action := values[gesture]; action != "" {
+				gestures[gesture] = action
+			}
+		}
+		if err := checkGestures(gestures); err != nil {
+			return c.error(err.Error())
+		}
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			m.Gestures = gestures
+			return nil
+		})
+		return c.listTweets()
 
-		i := indexOf(c.app.config.TweetNames, values["tweetName"])
-		c.app.config.TweetNames = append(c.app.config.TweetNames[:i], c.app.config.TweetNames[i+1:]...)
+	case "editTimeline":
+		return c.editTimeline()
 
-		c.app.SendEvent("config", c.app.config)
+	case "saveTimeline":
+		// poll interval is read as a string since it can be blank
+		var values struct {
+			TimelineSettings
+			PollInterval string `json:"pollinterval"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal save timeline config request %s: %s", request.Data, err))
+		}
+		values.TimelineSettings.PollInterval = 0
+		if values.PollInterval != "" {
+			values.TimelineSettings.PollInterval, err = strconv.Atoi(values.PollInterval)
+			if err != nil || values.TimelineSettings.PollInterval < minPollInterval {
+				return c.error(fmt.Sprintf("Check every must be at least %d seconds, not %s", minPollInterval, values.PollInterval))
+			}
+		}
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			m.Timeline = values.TimelineSettings
+			return nil
+		})
 		return c.listTweets()
 
-	case "listTweets":
+	case "editCommands":
+		return c.editCommands()
+
+	case "saveCommands":
+		var values struct {
+			Enabled   bool   `json:"enabled"`
+			AllowList string `json:"allowlist"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal save commands config request %s: %s", request.Data, err))
+		}
+		var allowList []string
+		for _, handle := range strings.FieldsFunc(values.AllowList, func(r rune) bool { return r == ',' || r == ' ' }) {
+			allowList = append(allowList, addAt(handle))
+		}
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			// messages sent before commands are turned on aren't run
+			if values.Enabled && !m.Commands.Enabled {
+				m.Commands.LastIDs = nil
+			}
+			m.Commands.Enabled = values.Enabled
+			m.Commands.AllowList = allowList
+			return nil
+		})
 		return c.listTweets()
 
+	case "listQueue":
+		return c.listQueue()
+
+	case "retryQueue":
+		c.app.RetryQueue(true)
+		return c.listQueue()
+
+	case "deleteQueued":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal delete queued config request %s: %s", request.Data, err))
+		}
+		c.app.DeleteQueued(values["queued"])
+		return c.listQueue()
+
+	case "listHistory":
+		return c.listHistory("")
+
+	case "exportHistoryCSV", "exportHistoryJSON":
+		format := ExportCSV
+		if request.Action == "exportHistoryJSON" {
+			format = ExportJSON
+		}
+		data, err := c.app.ExportHistory(format)
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not export history: %s", err))
+		}
+		path, err := filepath.Abs(exportFile("history", format))
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(data), 0644)
+		}
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not write history export: %s", err))
+		}
+		return c.listHistory(fmt.Sprintf("Exported the history to %s", path))
+
+	case "importExport":
+		return c.importExport(nil)
+
+	case "exportConfig":
+		var values struct {
+			Format     string `json:"format"`
+			Passphrase string `json:"passphrase"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal export config request %s: %s", request.Data, err))
+		}
+		data, err := c.app.ExportConfig(values.Format, values.Passphrase)
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not export: %s", err))
+		}
+		path, err := filepath.Abs(exportFile("config", values.Format))
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(data), 0600)
+		}
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not write export: %s", err))
+		}
+		return c.importExport([]string{fmt.Sprintf("Exported the tweets and settings to %s", path)})
+
+	case "importConfig":
+		var values struct {
+			ImportOptions
+			Source string `json:"source"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal import config request %s: %s", request.Data, err))
+		}
+		data, err := importSource(values.Source)
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not load %s: %s", values.Source, err))
+		}
+		report, err := c.app.ImportConfig(data, values.ImportOptions)
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not import: %s", err))
+		}
+		return c.importExport(report)
+
+	case "listGroups":
+		return c.listGroups()
+
+	case "newGroup":
+		return c.editGroup("")
+
+	case "editGroup":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal edit group config request %s: %s", request.Data, err))
+		}
+		return c.editGroup(values["group"])
+
+	case "saveGroup":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal save group config request %s: %s", request.Data, err))
+		}
+		name := strings.TrimSpace(values["name"])
+		if name == "" {
+			return c.error("A group needs a name")
+		}
+		// add the group or rename it (and move its tweets to the new name)
+		err = c.app.updateConfig(func(m *TwitterAppModel) error {
+			if name != values["previous"] && m.hasGroup(name) {
+				return fmt.Errorf("there is already a group called %s", name)
+			}
+			m.renameGroup(values["previous"], name)
+			return nil
+		})
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not save group: %s", err))
+		}
+		return c.listGroups()
+
+	case "confirmDeleteGroup":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal confirm delete group config request %s: %s", request.Data, err))
+		}
+		return c.confirmDeleteGroup(values["group"])
+
+	case "deleteGroup":
+		var values map[string]string
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal delete group config request %s: %s", request.Data, err))
+		}
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			m.deleteGroup(values["group"])
+			return nil
+		})
+		return c.listGroups()
+
 	case "newTweet":
-		return c.editTweet("")
+		return c.editTweet(TweetDetails{}, "")
 
 	case "editTweet":
 		var values map[string]string
@@ -120,7 +453,57 @@ func (c *ConfigService) Configure(request *model.ConfigurationRequest) (*suit.Co
 		if err != nil {
 			return c.error(fmt.Sprintf("Failed to unmarshal editTweet config request %s: %s", request.Data, err))
 		}
-		return c.editTweet(values["tweetName"])
+		tweet, ok := c.app.StoredTweet(values["tweetName"])
+		if !ok {
+			return c.error(fmt.Sprintf("There is no tweet called %s", values["tweetName"]))
+		}
+		return c.editTweet(tweet, "")
+
+	case "resetCount":
+		var values struct {
+			ID string `json:"id"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal reset count config request %s: %s", request.Data, err))
+		}
+		// the variation isn't reset, so the counter for avoiding duplicates keeps going
+		var tweet TweetDetails
+		err = c.app.updateConfig(func(m *TwitterAppModel) error {
+			name := m.tweetNameByID(values.ID)
+			if name == "" {
+				return fmt.Errorf("the tweet has been deleted")
+			}
+			tweet = m.Tweets[name]
+			tweet.Number = 0
+			m.Tweets[name] = tweet
+			return nil
+		})
+		if err != nil {
+			return c.error(fmt.Sprintf("Could not reset the count: %s", err))
+		}
+		return c.editTweet(tweet, "")
+
+	case "moveTweetUp", "moveTweetDown":
+		var values struct {
+			ID string `json:"id"`
+		}
+		err := json.Unmarshal(request.Data, &values)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal move tweet config request %s: %s", request.Data, err))
+		}
+		direction := -1
+		if request.Action == "moveTweetDown" {
+			direction = 1
+		}
+		// only saved if it moved (it can't go past either end)
+		c.app.updateConfig(func(m *TwitterAppModel) error {
+			if !m.moveTweet(m.tweetNameByID(values.ID), direction) {
+				return fmt.Errorf("tweet %s can't move", values.ID)
+			}
+			return nil
+		})
+		return c.listTweets()
 
 	case "saveTweet":
 		var values TweetDetails
@@ -130,22 +513,102 @@ func (c *ConfigService) Configure(request *model.ConfigurationRequest) (*suit.Co
 			return c.error(fmt.Sprintf("Failed to unmarshal save config request %s: %s", request.Data, err))
 		}
 
-		// We could check the length of the message here but giving an error would mean user had to start again
-		// So we just label it when displaying it
+		// An invalid template can't be sent, so that is an error (the length is checked when it's saved, so the form can be shown again)
+		if err := ValidateMessage(values.Message); err != nil {
+			return c.error(fmt.Sprintf("The message is not a valid template: %s", err))
+		}
+
+		// the alternatives are one field in the form so they're read separately
+		var alternatives struct {
+			Text string `json:"alternativetext"`
+		}
+		err = json.Unmarshal(request.Data, &alternatives)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal alternatives in save config request %s: %s", request.Data, err))
+		}
+		values.Alternatives = parseAlternatives(alternatives.Text)
+		for _, alternative := range values.Alternatives {
+			if err := ValidateMessage(alternative); err != nil {
+				return c.error(fmt.Sprintf("The alternative message %q is not a valid template: %s", alternative, err))
+			}
+		}
+		if values.Unique == UniqueAlternatives && len(values.Alternatives) == 0 {
+			return c.error("Add some alternative messages to take turns with, or choose another way to avoid duplicates")
+		}
+
+		values.Name = strings.TrimSpace(values.Name)
 
 		// check and add @ to To field if needed
-		if len(values.To) > 0 && values.To[0] != '@' {
-			values.To = "@" + values.To
+		values.To = addAt(values.To)
+
+		values.Media = strings.TrimSpace(values.Media)
+		if values.Media != "" && values.To != "" {
+			return c.error("Direct messages can't have an image, clear the image or the To field")
+		}
+
+		// the trigger fields are flat in the form so they're read separately
+		var trigger struct {
+			Topic     string `json:"triggertopic"`
+			Field     string `json:"triggerfield"`
+			Condition string `json:"triggercondition"`
+			Value     string `json:"triggervalue"`
+			Cooldown  string `json:"triggercooldown"`
+		}
+		err = json.Unmarshal(request.Data, &trigger)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal trigger in save config request %s: %s", request.Data, err))
+		}
+		values.Trigger = nil
+		if trigger.Topic != "" {
+			cooldown := 0
+			if trigger.Cooldown != "" {
+				cooldown, err = strconv.Atoi(trigger.Cooldown)
+				if err != nil || cooldown < 0 {
+					return c.error(fmt.Sprintf("Trigger cooldown must be a number of seconds, not %s", trigger.Cooldown))
+				}
+			}
+			values.Trigger = &TweetTrigger{
+				Topic:     trigger.Topic,
+				Field:     trigger.Field,
+				Condition: trigger.Condition,
+				Value:     trigger.Value,
+				Cooldown:  cooldown,
+			}
+		}
+
+		var schedule struct {
+			At       string `json:"scheduleat"`
+			Cron     string `json:"schedulecron"`
+			Timezone string `json:"scheduletimezone"`
+		}
+		err = json.Unmarshal(request.Data, &schedule)
+		if err != nil {
+			return c.error(fmt.Sprintf("Failed to unmarshal schedule in save config request %s: %s", request.Data, err))
+		}
+		values.Schedule = nil
+		if schedule.At != "" || schedule.Cron != "" {
+			values.Schedule = &TweetSchedule{
+				At:       schedule.At,
+				Cron:     schedule.Cron,
+				Timezone: schedule.Timezone,
+			}
+			if err := values.Schedule.Validate(); err != nil {
+				return c.error(fmt.Sprintf("Invalid schedule: %s", err))
+			}
 		}
 
-		// add tweet (map and slice) and save config (make new map &slice if no tweets exist yet)
-		if c.app.config.Tweets == nil {
-			c.app.config.Tweets = make(map[string]TweetDetails)
-			c.app.config.TweetNames = make([]string, 0)
+		// add the tweet or update it in place (found by its ID so it can be renamed) and save config
+		// errors (the name is taken or the message is too long) are shown on the form so nothing has to be entered again
+		err = c.app.updateConfig(func(m *TwitterAppModel) error {
+			// the group could have been deleted while the tweet was being edited
+			values.Group = m.groupOf(values)
+			return m.saveTweet(values)
+		})
+		if err != nil {
+			return c.editTweet(values, fmt.Sprintf("Could not save: %s", err))
 		}
-		c.app.config.Tweets[values.Name] = values
-		c.app.config.TweetNames = append(c.app.config.TweetNames, values.Name)
-		c.app.SendEvent("config", c.app.config)
+		c.app.UpdateTriggers()
+		c.app.UpdateSchedules()
 		return c.listTweets()
 
 	default:
@@ -178,24 +641,30 @@ func (c *ConfigService) error(message string) (*suit.ConfigurationScreen, error)
 
 // listAccounts is a config screen for displaying accounts with options for editing, deleting and controlling
 func (c *ConfigService) listAccounts() (*suit.ConfigurationScreen, error) {
-	subtitle := ""
-	if !c.app.Initialised {
-		subtitle = "INVALID ACCOUNT!"
+	var accountOptions []suit.ActionListOption
+	for _, username := range c.app.AccountNames() {
+		subtitle := ""
+		if !c.app.IsInitialised(username) {
+			subtitle = "INVALID ACCOUNT!"
+		} else if username == c.app.Config().DefaultAccount {
+			subtitle = "Default"
+		}
+		subtitle = strings.TrimPrefix(subtitle+" - "+c.rateLimitSummary(username), " - ")
+		accountOptions = append(accountOptions, suit.ActionListOption{
+			Title:    username,
+			Subtitle: subtitle,
+			Value:    username,
+		})
 	}
 	screen := suit.ConfigurationScreen{
 		Title: "Twitter App Config",
 		Sections: []suit.Section{
 			suit.Section{
-				Title: "Edit Account",
+				Title: "Edit Accounts",
 				Contents: []suit.Typed{
 					suit.ActionList{
-						Name: "account",
-						Options: []suit.ActionListOption{
-							suit.ActionListOption{
-								Title:    c.app.config.Account.Username,
-								Subtitle: subtitle,
-							},
-						},
+						Name:    "account",
+						Options: accountOptions,
 						PrimaryAction: &suit.ReplyAction{
 							Name:        "editAccount",
 							DisplayIcon: "pencil",
@@ -226,24 +695,168 @@ func (c *ConfigService) listAccounts() (*suit.ConfigurationScreen, error) {
 				DisplayClass: "success",
 				DisplayIcon:  "star",
 			},
+			suit.ReplyAction{
+				Label:        "Sign in with Twitter",
+				Name:         "signIn",
+				DisplayClass: "success",
+				DisplayIcon:  "twitter",
+			},
+		},
+	}
+
+	return &screen, nil
+}
+
+// signIn is a config screen for entering the consumer key and secret to sign in with Twitter
+func (c *ConfigService) signIn() (*suit.ConfigurationScreen, error) {
+	screen := suit.ConfigurationScreen{
+		Title: "Sign in with Twitter",
+		Sections: []suit.Section{
+			suit.Section{
+				Contents: []suit.Typed{
+					suit.StaticText{
+						Value: "Enter the consumer key and secret of your Twitter app (see: https://apps.twitter.com)",
+					},
+					suit.InputText{
+						Name:   "consumerkey",
+						Before: "Consumer Key",
+					},
+					suit.InputText{
+						Name:      "consumersecret",
+						Before:    "Consumer Secret",
+						InputType: "password",
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listAccounts",
+			},
+			suit.ReplyAction{
+				Label:        "Next",
+				Name:         "startSignIn",
+				DisplayClass: "success",
+				DisplayIcon:  "arrow-right",
+			},
 		},
 	}
+	return &screen, nil
+}
+
+// startSignIn is a config screen showing the link for authorising the app with Twitter, and for entering the PIN
+func (c *ConfigService) startSignIn(consumerKey, consumerSecret string) (*suit.ConfigurationScreen, error) {
+	authURL, token, err := c.app.StartSignIn(consumerKey, consumerSecret)
+	if err != nil {
+		return c.error(err.Error())
+	}
+	return c.enterPIN(authURL, token, "")
+}
 
+// enterPIN is a config screen showing authURL for the sign in with token, and for entering the PIN,
+// problem is shown above the form if it's not blank (e.g. when the PIN was wrong)
+func (c *ConfigService) enterPIN(authURL, token, problem string) (*suit.ConfigurationScreen, error) {
+	screen := suit.ConfigurationScreen{
+		Title: "Sign in with Twitter",
+		Sections: []suit.Section{
+			suit.Section{
+				Contents: []suit.Typed{
+					suit.StaticText{
+						Title: "1. Open this link, sign in to the account you want to use and authorise the app",
+						Value: authURL,
+					},
+					suit.StaticText{
+						Title: "2. Enter the PIN that Twitter shows you",
+					},
+					suit.InputText{
+						Name:   "pin",
+						Before: "PIN",
+					},
+					suit.InputHidden{
+						Name:  "token",
+						Value: token,
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listAccounts",
+			},
+			suit.ReplyAction{
+				Label:        "Sign in",
+				Name:         "finishSignIn",
+				DisplayClass: "success",
+				DisplayIcon:  "check",
+			},
+		},
+	}
+	if problem != "" {
+		screen.Sections[0].Contents = append([]suit.Typed{
+			suit.Alert{
+				Title:        problem,
+				DisplayClass: "danger",
+				DisplayIcon:  "warning",
+			},
+		}, screen.Sections[0].Contents...)
+	}
 	return &screen, nil
 }
 
+// rateLimitSummary describes how many tweets and direct messages the account can send before its rate limits reset
+func (c *ConfigService) rateLimitSummary(username string) string {
+	var parts []string
+	for _, endpoint := range []struct{ name, endpoint string }{
+		{"tweets", EndpointTweet},
+		{"DMs", EndpointDirectMessage},
+	} {
+		limit := c.app.RateLimit(username, endpoint.endpoint)
+		part := fmt.Sprintf("%d/%d %s left", limit.Remaining, limit.Limit, endpoint.name)
+		if limit.Remaining <= 0 {
+			part = fmt.Sprintf("NO %s left until %s", endpoint.name, limit.Reset.Format("15:04"))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
 // listTweets is a config screen for displaying tweets with options for editing, deleting and creating new ones
 func (c *ConfigService) listTweets() (*suit.ConfigurationScreen, error) {
 	var tweetOptions []suit.ActionListOption
-	for i, tweetName := range c.app.config.TweetNames {
+	config := c.app.Config()
+	for i, tweetName := range config.TweetNames {
 		subtitle := ""
-		tweet := c.app.config.Tweets[c.app.config.TweetNames[i]]
+		tweet := config.Tweets[tweetName]
 		// create edit actions
-		if len(tweet.Message) > 137 {
-			subtitle = "TOO LONG!"
+		if length, limit, err := MessageLength(tweet); err != nil {
+			subtitle = "INVALID TEMPLATE!"
+		} else if length > limit {
+			subtitle = fmt.Sprintf("TOO LONG! (%d/%d)", length, limit)
 		} else if tweet.To != "" {
 			subtitle = "DM"
 		}
+		if tweet.Account != "" {
+			subtitle += " from " + tweet.Account
+		}
+		if tweet.Media != "" {
+			subtitle += " (image)"
+		}
+		if tweet.Trigger != nil {
+			subtitle += " (triggered)"
+		}
+		if tweet.Schedule != nil {
+			subtitle += " (scheduled)"
+		}
+		if config.hasGroup(tweet.Group) {
+			subtitle += " in " + tweet.Group
+		}
+		if !tweet.LastSent.IsZero() {
+			subtitle += fmt.Sprintf(" - sent %d, last %s", tweet.Number, tweet.LastSent.Format("Mon 2 Jan 15:04"))
+		} else if tweet.Number > 0 {
+			subtitle += fmt.Sprintf(" - sent %d", tweet.Number)
+		}
 		tweetOptions = append(tweetOptions, suit.ActionListOption{
 			Title:    fmt.Sprintf("%d-%s", i+1, tweetName),
 			Subtitle: subtitle,
@@ -256,9 +869,6 @@ func (c *ConfigService) listTweets() (*suit.ConfigurationScreen, error) {
 			suit.Section{
 				Title: "Create or Edit Tweets",
 				Contents: []suit.Typed{
-					suit.StaticText{
-						Value: "To rename a tweet, edit it, save with a different name, then delete the one with the old name",
-					},
 					suit.ActionList{
 						Name:    "tweetName",
 						Options: tweetOptions,
@@ -287,8 +897,53 @@ func (c *ConfigService) listTweets() (*suit.ConfigurationScreen, error) {
 				DisplayIcon:  "at",
 			},
 			suit.ReplyAction{
-				Label:        "New Tweet",
-				Name:         "newTweet",
+				Label:       "Groups",
+				Name:        "listGroups",
+				DisplayIcon: "folder-o",
+			},
+			suit.ReplyAction{
+				Label:       "Schedules",
+				Name:        "listSchedules",
+				DisplayIcon: "clock-o",
+			},
+			suit.ReplyAction{
+				Label:       fmt.Sprintf("Queue (%d)", c.app.PendingCount()),
+				Name:        "listQueue",
+				DisplayIcon: "refresh",
+			},
+			suit.ReplyAction{
+				Label:       "History",
+				Name:        "listHistory",
+				DisplayIcon: "history",
+			},
+			suit.ReplyAction{
+				Label:       "Import/Export",
+				Name:        "importExport",
+				DisplayIcon: "exchange",
+			},
+			suit.ReplyAction{
+				Label:       "Display",
+				Name:        "editDisplay",
+				DisplayIcon: "eye",
+			},
+			suit.ReplyAction{
+				Label:       "Gestures",
+				Name:        "editGestures",
+				DisplayIcon: "hand-o-up",
+			},
+			suit.ReplyAction{
+				Label:       "Timeline",
+				Name:        "editTimeline",
+				DisplayIcon: "comments",
+			},
+			suit.ReplyAction{
+				Label:       "Commands",
+				Name:        "editCommands",
+				DisplayIcon: "terminal",
+			},
+			suit.ReplyAction{
+				Label:        "New Tweet",
+				Name:         "newTweet",
 				DisplayClass: "success",
 				DisplayIcon:  "star",
 			},
@@ -297,13 +952,751 @@ func (c *ConfigService) listTweets() (*suit.ConfigurationScreen, error) {
 	return &screen, nil
 }
 
-// editTweet is a config screen for editing tweets
-func (c *ConfigService) editTweet(tweetName string) (*suit.ConfigurationScreen, error) {
-	tweet := TweetDetails{}
+// listSchedules is a config screen for displaying scheduled tweets and when they will next be sent
+func (c *ConfigService) listSchedules() (*suit.ConfigurationScreen, error) {
+	var scheduleOptions []suit.ActionListOption
+	config := c.app.Config()
+	for i, tweetName := range config.TweetNames {
+		tweet := config.Tweets[tweetName]
+		if tweet.Schedule == nil {
+			continue
+		}
+		subtitle := "Not due again"
+		if next := c.app.NextScheduled(tweetName); !next.IsZero() {
+			subtitle = "Next: " + next.Format("Mon 2 Jan 2006 15:04 MST")
+		}
+		if tweet.Schedule.Cron != "" {
+			subtitle += " (repeats " + tweet.Schedule.Cron + ")"
+		}
+		scheduleOptions = append(scheduleOptions, suit.ActionListOption{
+			Title:    fmt.Sprintf("%d-%s", i+1, tweetName),
+			Subtitle: subtitle,
+			Value:    tweetName,
+		})
+	}
+	contents := []suit.Typed{}
+	if len(scheduleOptions) == 0 {
+		contents = append(contents, suit.StaticText{
+			Value: "No tweets are scheduled. Edit a tweet to add a schedule",
+		})
+	} else {
+		contents = append(contents, suit.ActionList{
+			Name:    "tweetName",
+			Options: scheduleOptions,
+			PrimaryAction: &suit.ReplyAction{
+				Name:        "editTweet",
+				DisplayIcon: "pencil",
+			},
+		})
+	}
+	screen := suit.ConfigurationScreen{
+		Title: "Schedules",
+		Sections: []suit.Section{
+			suit.Section{
+				Title:    "Scheduled Tweets",
+				Contents: contents,
+			},
+		},
+		Actions: []suit.Typed{
+			suit.CloseAction{
+				Label: "Close",
+			},
+			suit.ReplyAction{
+				Label:        "Tweets",
+				Name:         "listTweets",
+				DisplayIcon:  "twitter",
+				DisplayClass: "info",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// editDisplay is a config screen for the scrolling text settings
+func (c *ConfigService) editDisplay() (*suit.ConfigurationScreen, error) {
+	display := c.app.displaySettings()
+	speed := ""
+	if display.ScrollSpeed > 0 {
+		speed = strconv.Itoa(display.ScrollSpeed)
+	}
+	confirmTime := ""
+	if display.ConfirmTime > 0 {
+		confirmTime = strconv.Itoa(display.ConfirmTime)
+	}
+	undoTime := ""
+	if display.UndoTime > 0 {
+		undoTime = strconv.Itoa(display.UndoTime)
+	}
+	screen := suit.ConfigurationScreen{
+		Title: "Display",
+		Sections: []suit.Section{
+			suit.Section{
+				Title: "Scrolling Text",
+				Contents: []suit.Typed{
+					suit.Switch{
+						Name:    "scrollnames",
+						Title:   "Scroll the tweet's name when you choose it",
+						Checked: display.ScrollNames,
+					},
+					suit.Switch{
+						Name:    "scrollmessages",
+						Title:   "Scroll the message before sending it (tap to cancel)",
+						Checked: display.ScrollMessages,
+					},
+					suit.InputText{
+						Name:        "scrollspeed",
+						Before:      "Speed",
+						After:       "ms per pixel",
+						Placeholder: strconv.Itoa(defaultScrollSpeed),
+						Value:       speed,
+					},
+					suit.InputText{
+						Name:        "scrollcolour",
+						Before:      "Colour",
+						Placeholder: defaultScrollColour,
+						Value:       display.ScrollColour,
+					},
+				},
+			},
+			suit.Section{
+				Title: "Sending",
+				Contents: []suit.Typed{
+					suit.Switch{
+						Name:    "confirmsend",
+						Title:   "Tap again to confirm after double tapping to send (the number flashes)",
+						Checked: display.ConfirmSend,
+					},
+					suit.InputText{
+						Name:        "confirmtime",
+						Before:      "Confirm within",
+						After:       "seconds",
+						Placeholder: strconv.Itoa(defaultConfirmTime),
+						Value:       confirmTime,
+					},
+					suit.Switch{
+						Name:    "undosend",
+						Title:   "Tap after sending a tweet to delete it (undo)",
+						Checked: display.UndoSend,
+					},
+					suit.InputText{
+						Name:        "undotime",
+						Before:      "Undo within",
+						After:       "seconds",
+						Placeholder: strconv.Itoa(defaultUndoTime),
+						Value:       undoTime,
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listTweets",
+			},
+			suit.ReplyAction{
+				Label:        "Save",
+				Name:         "saveDisplay",
+				DisplayClass: "success",
+				DisplayIcon:  "save",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// editGestures is a config screen for choosing what the airwheel, flicks and hovering do
+func (c *ConfigService) editGestures() (*suit.ConfigurationScreen, error) {
+	var contents []suit.Typed
+	contents = append(contents, suit.StaticText{
+		Value: "Tap left/right to change tweets and double tap to send are always on",
+	})
+	for _, gesture := range gestureNames {
+		actions := actionNames
+		if gesture == GestureAirWheel {
+			actions = []string{ActionNone, ActionScroll}
+		}
+		current := c.app.GestureAction(gesture)
+		var options []suit.RadioGroupOption
+		for _, action := range actions {
+			options = append(options, suit.RadioGroupOption{
+				Title:    actionTitles[action],
+				Value:    action,
+				Selected: action == current,
+			})
+		}
+		contents = append(contents, suit.RadioGroup{
+			Name:    gesture,
+			Title:   gestureTitles[gesture],
+			Options: options,
+		})
+	}
+
+	screen := suit.ConfigurationScreen{
+		Title: "Gestures",
+		Sections: []suit.Section{
+			suit.Section{
+				Contents: contents,
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listTweets",
+			},
+			suit.ReplyAction{
+				Label:        "Save",
+				Name:         "saveGestures",
+				DisplayClass: "success",
+				DisplayIcon:  "save",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// editTimeline is a config screen for the settings for showing mentions, direct messages and tweets on the spheramid
+func (c *ConfigService) editTimeline() (*suit.ConfigurationScreen, error) {
+	timeline := c.app.Config().Timeline
+	interval := ""
+	if timeline.PollInterval > 0 {
+		interval = strconv.Itoa(timeline.PollInterval)
+	}
+	screen := suit.ConfigurationScreen{
+		Title: "Timeline",
+		Sections: []suit.Section{
+			suit.Section{
+				Title:    "Show on the Spheramid",
+				Subtitle: fmt.Sprintf("%d unread", len(c.app.Unread())),
+				Contents: []suit.Typed{
+					suit.Switch{
+						Name:    "enabled",
+						Title:   "Check Twitter",
+						Checked: timeline.Enabled,
+					},
+					suit.Switch{
+						Name:    "mentions",
+						Title:   "Mentions",
+						Checked: timeline.Mentions,
+					},
+					suit.Switch{
+						Name:    "directmessages",
+						Title:   "Direct messages",
+						Checked: timeline.DirectMessages,
+					},
+					suit.Switch{
+						Name:    "home",
+						Title:   "Home timeline",
+						Checked: timeline.Home,
+					},
+					suit.InputText{
+						Name:        "pollinterval",
+						Before:      "Check every",
+						After:       "seconds",
+						Placeholder: strconv.Itoa(defaultPollInterval),
+						Value:       interval,
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listTweets",
+			},
+			suit.ReplyAction{
+				Label:        "Save",
+				Name:         "saveTimeline",
+				DisplayClass: "success",
+				DisplayIcon:  "save",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// editCommands is a config screen for turning on direct message commands and who can send them
+func (c *ConfigService) editCommands() (*suit.ConfigurationScreen, error) {
+	commands := c.app.Config().Commands
+	screen := suit.ConfigurationScreen{
+		Title: "Commands",
+		Sections: []suit.Section{
+			suit.Section{
+				Title: "Control the Sphere with Direct Messages",
+				Contents: []suit.Typed{
+					suit.StaticText{
+						Value: commandHelp + ". Replies are sent as direct messages. Only messages from the allowed users are run",
+					},
+					suit.Switch{
+						Name:    "enabled",
+						Title:   "Run commands",
+						Checked: commands.Enabled,
+					},
+					suit.InputText{
+						Name:        "allowlist",
+						Before:      "Allowed users",
+						Placeholder: "@someone, @someoneelse",
+						Value:       strings.Join(commands.AllowList, ", "),
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listTweets",
+			},
+			suit.ReplyAction{
+				Label:        "Save",
+				Name:         "saveCommands",
+				DisplayClass: "success",
+				DisplayIcon:  "save",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// listQueue is a config screen for displaying messages waiting to be retried, with options to retry now or delete them
+func (c *ConfigService) listQueue() (*suit.ConfigurationScreen, error) {
+	var queueOptions []suit.ActionListOption
+	for _, item := range c.app.PendingMessages() {
+		to := "tweet"
+		if item.To != "" {
+			to = "DM to " + item.To
+		}
+		queueOptions = append(queueOptions, suit.ActionListOption{
+			Title: fmt.Sprintf("%s (%s from %s)", item.Message, to, item.Account),
+			Subtitle: fmt.Sprintf("%d attempts, next at %s: %s",
+				item.Attempts, item.NextTry.Format("15:04:05"), item.LastError),
+			Value: item.ID,
+		})
+	}
+	contents := []suit.Typed{}
+	if len(queueOptions) == 0 {
+		contents = append(contents, suit.StaticText{
+			Value: "No messages are waiting to be sent",
+		})
+	} else {
+		contents = append(contents, suit.ActionList{
+			Name:    "queued",
+			Options: queueOptions,
+			SecondaryAction: &suit.ReplyAction{
+				Name:         "deleteQueued",
+				Label:        "Delete",
+				DisplayIcon:  "trash",
+				DisplayClass: "danger",
+			},
+		})
+	}
+	screen := suit.ConfigurationScreen{
+		Title: "Queue",
+		Sections: []suit.Section{
+			suit.Section{
+				Title:    "Messages Waiting to be Retried",
+				Contents: contents,
+			},
+		},
+		Actions: []suit.Typed{
+			suit.CloseAction{
+				Label: "Close",
+			},
+			suit.ReplyAction{
+				Label:        "Tweets",
+				Name:         "listTweets",
+				DisplayIcon:  "twitter",
+				DisplayClass: "info",
+			},
+			suit.ReplyAction{
+				Label:        "Retry Now",
+				Name:         "retryQueue",
+				DisplayIcon:  "refresh",
+				DisplayClass: "success",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// listHistory is a config screen for displaying the recently sent tweets/messages and whether they worked,
+// with options to export the history. message is shown at the top if it's not blank (e.g. where it was exported to)
+func (c *ConfigService) listHistory(message string) (*suit.ConfigurationScreen, error) {
+	contents := []suit.Typed{}
+	if message != "" {
+		contents = append(contents, suit.Alert{
+			Title:        message,
+			DisplayClass: "success",
+		})
+	}
+	entries := c.app.History()
+	if len(entries) > historyShown {
+		entries = entries[:historyShown]
+	}
+	for _, entry := range entries {
+		to := "tweet"
+		if entry.To != "" {
+			to = "DM to " + entry.To
+		}
+		if entry.Tweet != "" {
+			to = entry.Tweet + " - " + to
+		}
+		status := "Sent"
+		if entry.ID != "" {
+			status += " (ID " + entry.ID + ")"
+		}
+		if entry.Queued {
+			status = "Queued to retry: " + entry.Error
+		} else if !entry.Success {
+			status = "FAILED: " + entry.Error
+		}
+		if entry.Attempts > 1 {
+			status += fmt.Sprintf(" after %d attempts", entry.Attempts)
+		}
+		contents = append(contents, suit.StaticText{
+			Title: fmt.Sprintf("%s %s from %s", entry.Time.Format("Mon 2 Jan 15:04:05"), to, entry.Account),
+			Value: entry.Message + " - " + status,
+		})
+	}
+	if len(entries) == 0 {
+		contents = append(contents, suit.StaticText{
+			Value: "Nothing has been sent yet",
+		})
+	}
+	screen := suit.ConfigurationScreen{
+		Title: "History",
+		Sections: []suit.Section{
+			suit.Section{
+				Title:    fmt.Sprintf("Recently Sent (the last %d are kept)", historySize),
+				Contents: contents,
+			},
+		},
+		Actions: []suit.Typed{
+			suit.CloseAction{
+				Label: "Close",
+			},
+			suit.ReplyAction{
+				Label:        "Tweets",
+				Name:         "listTweets",
+				DisplayIcon:  "twitter",
+				DisplayClass: "info",
+			},
+			suit.ReplyAction{
+				Label:       "Export CSV",
+				Name:        "exportHistoryCSV",
+				DisplayIcon: "download",
+			},
+			suit.ReplyAction{
+				Label:       "Export JSON",
+				Name:        "exportHistoryJSON",
+				DisplayIcon: "download",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// importExport is a config screen for exporting the tweets and settings to a file and importing them
+// (e.g. from another Sphere), results are shown at the top if there are any (what was imported, or where it was exported to)
+func (c *ConfigService) importExport(results []string) (*suit.ConfigurationScreen, error) {
+	sections := []suit.Section{}
+	if len(results) > 0 {
+		contents := []suit.Typed{}
+		for _, result := range results {
+			contents = append(contents, suit.StaticText{
+				Value: result,
+			})
+		}
+		sections = append(sections, suit.Section{
+			Title:    "Done",
+			Contents: contents,
+		})
+	}
+	sections = append(sections,
+		suit.Section{
+			Title: "Export",
+			Contents: []suit.Typed{
+				suit.StaticText{
+					Value: "Saves the tweets, groups, accounts and settings to " + exportFile("config", "json") + " (or .yaml) in the app's folder",
+				},
+				suit.RadioGroup{
+					Name:  "format",
+					Title: "Format",
+					Options: []suit.RadioGroupOption{
+						suit.RadioGroupOption{
+							Title:    "JSON",
+							Value:    ExportJSON,
+							Selected: true,
+						},
+						suit.RadioGroupOption{
+							Title: "YAML",
+							Value: ExportYAML,
+						},
+					},
+				},
+				suit.InputText{
+					Name:        "passphrase",
+					Before:      "Passphrase",
+					Placeholder: "To include the accounts' secrets (encrypted with this), blank to leave them out",
+					InputType:   "password",
+				},
+			},
+		},
+		suit.Section{
+			Title: "Import",
+			Contents: []suit.Typed{
+				suit.InputText{
+					Name:        "source",
+					Before:      "From",
+					Placeholder: "File path or URL of an export, or paste the JSON",
+				},
+				suit.RadioGroup{
+					Name:  "mode",
+					Title: "Stored tweets",
+					Options: []suit.RadioGroupOption{
+						suit.RadioGroupOption{
+							Title:    "Keep them and add the imported ones",
+							Value:    ImportMerge,
+							Selected: true,
+						},
+						suit.RadioGroupOption{
+							Title: "Replace them with the imported ones",
+							Value: ImportReplace,
+						},
+					},
+				},
+				suit.RadioGroup{
+					Name:  "conflicts",
+					Title: "When a tweet has the same name as one already here",
+					Options: []suit.RadioGroupOption{
+						suit.RadioGroupOption{
+							Title:    "Keep the one here",
+							Value:    ConflictSkip,
+							Selected: true,
+						},
+						suit.RadioGroupOption{
+							Title: "Replace it with the imported one",
+							Value: ConflictOverwrite,
+						},
+						suit.RadioGroupOption{
+							Title: "Add the imported one with a number after its name",
+							Value: ConflictRename,
+						},
+					},
+				},
+				suit.Switch{
+					Name:  "settings",
+					Title: "Import the display, gestures, timeline and commands settings too",
+				},
+				suit.StaticText{
+					Value: "Accounts that aren't here are added if they were exported with a passphrase - enter it above",
+				},
+			},
+		})
+	screen := suit.ConfigurationScreen{
+		Title:    "Import/Export",
+		Sections: sections,
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label:        "Tweets",
+				Name:         "listTweets",
+				DisplayIcon:  "twitter",
+				DisplayClass: "info",
+			},
+			suit.ReplyAction{
+				Label:       "Export",
+				Name:        "exportConfig",
+				DisplayIcon: "download",
+			},
+			suit.ReplyAction{
+				Label:        "Import",
+				Name:         "importConfig",
+				DisplayIcon:  "upload",
+				DisplayClass: "success",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// listGroups is a config screen for displaying the groups of tweets with options for editing and deleting them
+func (c *ConfigService) listGroups() (*suit.ConfigurationScreen, error) {
+	var groupOptions []suit.ActionListOption
+	config := c.app.Config()
+	for i, group := range config.Groups {
+		groupOptions = append(groupOptions, suit.ActionListOption{
+			Title:    fmt.Sprintf("%d-%s", i+1, group),
+			Subtitle: fmt.Sprintf("%d tweets, shown as %s", len(config.GroupTweetNames(group)), groupInitial(group)),
+			Value:    group,
+		})
+	}
+	contents := []suit.Typed{
+		suit.StaticText{
+			Value: "Flick left/right on the spheramid to choose a group, then tap to choose a tweet in it. Tweets without a group are in " + groupTitle(""),
+		},
+	}
+	if len(groupOptions) > 0 {
+		contents = append(contents, suit.ActionList{
+			Name:    "group",
+			Options: groupOptions,
+			PrimaryAction: &suit.ReplyAction{
+				Name:        "editGroup",
+				DisplayIcon: "pencil",
+			},
+			SecondaryAction: &suit.ReplyAction{
+				Name:         "confirmDeleteGroup",
+				Label:        "Delete",
+				DisplayIcon:  "trash",
+				DisplayClass: "danger",
+			},
+		})
+	}
+	screen := suit.ConfigurationScreen{
+		Title: "Groups",
+		Sections: []suit.Section{
+			suit.Section{
+				Title:    "Groups of Tweets",
+				Contents: contents,
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label:        "Tweets",
+				Name:         "listTweets",
+				DisplayIcon:  "twitter",
+				DisplayClass: "info",
+			},
+			suit.ReplyAction{
+				Label:        "New Group",
+				Name:         "newGroup",
+				DisplayClass: "success",
+				DisplayIcon:  "star",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// editGroup is a config screen for naming a new group or renaming one (blank for a new group)
+func (c *ConfigService) editGroup(group string) (*suit.ConfigurationScreen, error) {
+	title := "New Group"
+	if group != "" {
+		title = "Edit Group"
+	}
+	screen := suit.ConfigurationScreen{
+		Title: title,
+		Sections: []suit.Section{
+			suit.Section{
+				Contents: []suit.Typed{
+					suit.InputText{
+						Name:        "name",
+						Before:      "Name",
+						Placeholder: "The first letter is shown on the spheramid",
+						Value:       group,
+					},
+					suit.InputHidden{
+						Name:  "previous",
+						Value: group,
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listGroups",
+			},
+			suit.ReplyAction{
+				Label:        "Save",
+				Name:         "saveGroup",
+				DisplayClass: "success",
+				DisplayIcon:  "save",
+			},
+		},
+	}
+	return &screen, nil
+}
+
+// editTweet is a config screen for editing tweets (or creating one if tweet has no ID),
+// problem is shown above the form if it's not blank (e.g. when saving didn't work)
+func (c *ConfigService) editTweet(tweet TweetDetails, problem string) (*suit.ConfigurationScreen, error) {
 	title := "New Tweet/Message"
-	if tweetName != "" {
+	if tweet.ID != "" {
 		title = "Edit Tweet/Message"
-		tweet = c.app.config.Tweets[tweetName]
+	}
+	// blank account value means use the default account
+	accountOptions := []suit.RadioGroupOption{
+		suit.RadioGroupOption{
+			Title:    "Default account",
+			Value:    "",
+			Selected: tweet.Account == "",
+		},
+	}
+	for _, username := range c.app.AccountNames() {
+		accountOptions = append(accountOptions, suit.RadioGroupOption{
+			Title:    username,
+			Value:    username,
+			Selected: tweet.Account == username,
+		})
+	}
+	groupOptions := []suit.RadioGroupOption{
+		suit.RadioGroupOption{
+			Title:    "None",
+			Value:    "",
+			Selected: tweet.Group == "",
+		},
+	}
+	for _, group := range c.app.Config().Groups {
+		groupOptions = append(groupOptions, suit.RadioGroupOption{
+			Title:    group,
+			Value:    group,
+			Selected: tweet.Group == group,
+		})
+	}
+	uniqueOptions := []suit.RadioGroupOption{}
+	for _, unique := range uniqueNames {
+		uniqueOptions = append(uniqueOptions, suit.RadioGroupOption{
+			Title:    uniqueTitles[unique],
+			Value:    unique,
+			Selected: tweet.uniqueness() == unique,
+		})
+	}
+	trigger := TweetTrigger{Condition: ConditionAny}
+	if tweet.Trigger != nil {
+		trigger = *tweet.Trigger
+	}
+	cooldown := ""
+	if trigger.Cooldown > 0 {
+		cooldown = strconv.Itoa(trigger.Cooldown)
+	}
+	// show what the message will look like when it's next sent
+	preview := "Preview: "
+	if tweet.Message != "" {
+		next := tweet
+		next.Number++
+		next.Variation++
+		message, err := RenderMessage(next, NewTemplateData(next, time.Now(), nil))
+		if err != nil {
+			preview = "INVALID TEMPLATE! " + err.Error()
+		} else {
+			preview += message
+		}
+	}
+	schedule := TweetSchedule{}
+	if tweet.Schedule != nil {
+		schedule = *tweet.Schedule
+	}
+	conditionOptions := []suit.RadioGroupOption{}
+	for _, condition := range []struct{ title, value string }{
+		{"Any event", ConditionAny},
+		{"Equals", ConditionEquals},
+		{"Not equal to", ConditionNotEquals},
+		{"Greater than", ConditionGreaterThan},
+		{"Less than", ConditionLessThan},
+	} {
+		conditionOptions = append(conditionOptions, suit.RadioGroupOption{
+			Title:    condition.title,
+			Value:    condition.value,
+			Selected: trigger.Condition == condition.value,
+		})
 	}
 	screen := suit.ConfigurationScreen{
 		Title: title,
@@ -320,18 +1713,114 @@ func (c *ConfigService) editTweet(tweetName string) (*suit.ConfigurationScreen,
 					suit.InputText{
 						Name:        "message",
 						Before:      "Message",
-						Placeholder: "Up to 140 characters",
+						Placeholder: "Up to 280 characters (10000 for direct messages)",
 						Value:       tweet.Message,
 					},
+					suit.StaticText{
+						Value: "You can use {{.Count}}, {{.Time}}, {{.Date}}, {{.Weekday}}, {{.Value}} and {{.Temperature}} (from a trigger event) and {{choose \"one\" \"two\"}} for a random choice. {{.Count}} is how many times it has been sent",
+					},
+					suit.StaticText{
+						Value: preview,
+					},
 					suit.InputText{
 						Name:        "to",
 						Before:      "To",
 						Placeholder: "Complete this field to make it a direct message instead of a public tweet",
 						Value:       tweet.To,
 					},
+					suit.InputText{
+						Name:        "media",
+						Before:      "Image",
+						Placeholder: "File path, URL or \"snapshot\" of the LED matrix (optional, not for DMs)",
+						Value:       tweet.Media,
+					},
+					suit.RadioGroup{
+						Name:    "account",
+						Title:   "Send from",
+						Options: accountOptions,
+					},
+					suit.RadioGroup{
+						Name:    "group",
+						Title:   "Group",
+						Options: groupOptions,
+					},
+					suit.RadioGroup{
+						Name:    "unique",
+						Title:   "Avoid duplicates",
+						Options: uniqueOptions,
+					},
+					suit.InputText{
+						Name:        "alternativetext",
+						Before:      "Alternatives",
+						Placeholder: "Other messages to take turns with, separated by " + alternativeSeparator,
+						Value:       strings.Join(tweet.Alternatives, " "+alternativeSeparator+" "),
+					},
 					suit.InputHidden{
-						Name:  "number",
-						Value: fmt.Sprintf("%d", tweet.Number),
+						Name:  "id",
+						Value: tweet.ID,
+					},
+				},
+			},
+			suit.Section{
+				Title: "Trigger (optional)",
+				Contents: []suit.Typed{
+					suit.StaticText{
+						Value: "Send this automatically when a device event matches, e.g. topic $device/<id>/channel/<channel>/event/state",
+					},
+					suit.InputText{
+						Name:        "triggertopic",
+						Before:      "Topic",
+						Placeholder: "Leave blank for no trigger",
+						Value:       trigger.Topic,
+					},
+					suit.InputText{
+						Name:        "triggerfield",
+						Before:      "Field",
+						Placeholder: "Key in the event to check (optional)",
+						Value:       trigger.Field,
+					},
+					suit.RadioGroup{
+						Name:    "triggercondition",
+						Title:   "Condition",
+						Options: conditionOptions,
+					},
+					suit.InputText{
+						Name:   "triggervalue",
+						Before: "Value",
+						Value:  trigger.Value,
+					},
+					suit.InputText{
+						Name:        "triggercooldown",
+						Before:      "Cooldown",
+						After:       "seconds",
+						Placeholder: fmt.Sprintf("%d", int(defaultTriggerCooldown.Seconds())),
+						Value:       cooldown,
+					},
+				},
+			},
+			suit.Section{
+				Title: "Schedule (optional)",
+				Contents: []suit.Typed{
+					suit.StaticText{
+						Value: "Send this once at a time and/or regularly with a cron spec (minute hour day month weekday), e.g. 0 7 * * 1-5 for 7am on weekdays",
+					},
+					suit.InputText{
+						Name:        "scheduleat",
+						Before:      "Once at",
+						Placeholder: scheduleTimeFormat,
+						Value:       schedule.At,
+					},
+					suit.InputText{
+						Name:        "schedulecron",
+						Before:      "Repeat",
+						Placeholder: "Cron spec or @hourly, @daily, @weekly, @monthly",
+						Value:       schedule.Cron,
+					},
+					suit.InputText{
+						Name:        "scheduletimezone",
+						Before:      "Timezone",
+						Placeholder: "e.g. Australia/Brisbane, blank for the Sphere's time",
+						Value:       schedule.Timezone,
 					},
 				},
 			},
@@ -349,14 +1838,44 @@ func (c *ConfigService) editTweet(tweetName string) (*suit.ConfigurationScreen,
 			},
 		},
 	}
+	if tweet.ID != "" {
+		// moving and resetting don't save other changes on the form
+		screen.Actions = append(screen.Actions,
+			suit.ReplyAction{
+				Label:       fmt.Sprintf("Reset Count (%d)", tweet.Number),
+				Name:        "resetCount",
+				DisplayIcon: "undo",
+			},
+			suit.ReplyAction{
+				Label:       "Move Up",
+				Name:        "moveTweetUp",
+				DisplayIcon: "arrow-up",
+			},
+			suit.ReplyAction{
+				Label:       "Move Down",
+				Name:        "moveTweetDown",
+				DisplayIcon: "arrow-down",
+			})
+	}
+	if problem != "" {
+		screen.Sections[0].Contents = append([]suit.Typed{
+			suit.Alert{
+				Title:        problem,
+				DisplayClass: "danger",
+				DisplayIcon:  "warning",
+			},
+		}, screen.Sections[0].Contents...)
+	}
 	return &screen, nil
 }
 
 // editAccount is a config screen for editing or creating details for a Twitter Account
-func (c *ConfigService) editAccount(config *TwitterAppModel) (*suit.ConfigurationScreen, error) {
+func (c *ConfigService) editAccount(account AccountDetails) (*suit.ConfigurationScreen, error) {
 	var title string
-	if config.Account.Username != "" {
+	secretPlaceholder := ""
+	if account.Username != "" {
 		title = "Editing Twitter Account"
+		secretPlaceholder = "Leave blank to keep the saved secret"
 	} else {
 		title = "New Twitter Account"
 	}
@@ -370,7 +1889,16 @@ func (c *ConfigService) editAccount(config *TwitterAppModel) (*suit.Configuratio
 						Name:        "username",
 						Before:      "Username",
 						Placeholder: "@...",
-						Value:       config.Account.Username,
+						Value:       account.Username,
+					},
+					suit.InputHidden{
+						Name:  "previous",
+						Value: account.Username,
+					},
+					suit.Switch{
+						Name:    "default",
+						Title:   "Default account",
+						Checked: account.Username != "" && account.Username == c.app.Config().DefaultAccount,
 					},
 					suit.StaticText{
 						Value: "See: https://dev.twitter.com/oauth/overview/application-owner-access-tokens",
@@ -378,30 +1906,32 @@ func (c *ConfigService) editAccount(config *TwitterAppModel) (*suit.Configuratio
 					suit.InputText{
 						Name:   "consumerkey",
 						Before: "Consumer Key",
-						Value:  config.Account.ConsumerKey,
+						Value:  account.ConsumerKey,
 					},
 					suit.InputText{
-						Name:   "consumersecret",
-						Before: "Consumer Secret",
-						Value:  config.Account.ConsumerSecret,
+						Name:        "consumersecret",
+						Before:      "Consumer Secret",
+						Placeholder: secretPlaceholder,
+						InputType:   "password",
 					},
 					suit.InputText{
 						Name:   "accesstoken",
 						Before: "Access Token",
-						Value:  config.Account.AccessToken,
+						Value:  account.AccessToken,
 					},
 					suit.InputText{
-						Name:   "accesstokensecret",
-						Before: "Access Token Secret",
-						Value:  config.Account.AccessTokenSecret,
+						Name:        "accesstokensecret",
+						Before:      "Access Token Secret",
+						Placeholder: secretPlaceholder,
+						InputType:   "password",
 					},
 				},
 			},
 		},
 		Actions: []suit.Typed{
-			suit.CloseAction{
-				Label: "Close",
-				//				Name:  "listAccounts",
+			suit.ReplyAction{
+				Label: "Cancel",
+				Name:  "listAccounts",
 			},
 			suit.ReplyAction{
 				Label:        "Save",
@@ -419,7 +1949,7 @@ func (c *ConfigService) confirmDeleteAccount(id string) (*suit.ConfigurationScre
 	return &suit.ConfigurationScreen{
 		Sections: []suit.Section{
 			suit.Section{
-				Title: "Confirm Deletion of " + c.app.config.Account.Username,
+				Title: "Confirm Deletion of " + id,
 				Contents: []suit.Typed{
 					suit.Alert{
 						Title:        "Do you really want to delete this Twitter Account?",
@@ -484,6 +2014,41 @@ func (c *ConfigService) confirmDeleteTweet(name string) (*suit.ConfigurationScre
 	}, nil
 }
 
+// confirmDeleteGroup is a config screen for confirming/cancelling deleting of a group (its tweets are kept)
+func (c *ConfigService) confirmDeleteGroup(group string) (*suit.ConfigurationScreen, error) {
+	return &suit.ConfigurationScreen{
+		Sections: []suit.Section{
+			suit.Section{
+				Title: "Confirm Deletion of group: " + group,
+				Contents: []suit.Typed{
+					suit.Alert{
+						Title:        "Do you really want to delete this group? Its tweets won't be deleted",
+						DisplayClass: "danger",
+						DisplayIcon:  "warning",
+					},
+					suit.InputHidden{
+						Name:  "group",
+						Value: group,
+					},
+				},
+			},
+		},
+		Actions: []suit.Typed{
+			suit.ReplyAction{
+				Label:       "Cancel",
+				Name:        "listGroups",
+				DisplayIcon: "close",
+			},
+			suit.ReplyAction{
+				Label:        "Confirm - Delete",
+				Name:         "deleteGroup",
+				DisplayClass: "warning",
+				DisplayIcon:  "check",
+			},
+		},
+	}, nil
+}
+
 // indexOf finds the position of a value in a slice, returns -1 if not found
 func indexOf(slice []string, value string) int {
 	for p, v := range slice {
@@ -493,3 +2058,11 @@ func indexOf(slice []string, value string) int {
 	}
 	return -1
 }
+
+// addAt adds @ to the start of a Twitter username if it's not blank and doesn't have one
+func addAt(username string) string {
+	if len(username) > 0 && username[0] != '@' {
+		return "@" + username
+	}
+	return username
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ninjasphere/go-ninja/model"
+	"github.com/ninjasphere/go-ninja/suit"
+)
+
+// configure sends a config screen request with data (as JSON) and returns the screen
+func configure(t *testing.T, c *ConfigService, action string, data interface{}) *suit.ConfigurationScreen {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen, err := c.Configure(&model.ConfigurationRequest{Action: action, Data: raw})
+	if err != nil {
+		t.Fatalf("%s returned %v", action, err)
+	}
+	return screen
+}
+
+// screenAlert returns the message of the alert at the top of screen (the error or problem), blank if there isn't one
+func screenAlert(screen *suit.ConfigurationScreen) string {
+	if len(screen.Sections) == 0 || len(screen.Sections[0].Contents) == 0 {
+		return ""
+	}
+	alert, ok := screen.Sections[0].Contents[0].(suit.Alert)
+	if !ok {
+		return ""
+	}
+	if alert.Title == "Error" {
+		return alert.Subtitle
+	}
+	return alert.Title
+}
+
+func TestConfigureSaveTweet(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	c := &ConfigService{a}
+
+	for _, name := range []string{"one", "two"} {
+		screen := configure(t, c, "saveTweet", map[string]string{"name": name, "message": "Hello " + name})
+		if alert := screenAlert(screen); alert != "" {
+			t.Fatalf("saving %s showed %q", name, alert)
+		}
+	}
+	one, _ := a.StoredTweet("one")
+	if one.ID == "" || !reflect.DeepEqual(a.TweetNames(), []string{"one", "two"}) {
+		t.Fatalf("stored tweets are %q, want one and two (with IDs)", a.TweetNames())
+	}
+
+	// the form is shown again with the problem, so nothing has to be entered again
+	screen := configure(t, c, "saveTweet", map[string]string{"name": "two", "message": "Another"})
+	if alert := screenAlert(screen); !strings.Contains(alert, "already a tweet called two") {
+		t.Errorf("saving a second tweet called two showed %q", alert)
+	}
+	screen = configure(t, c, "saveTweet", map[string]string{"name": "long", "message": strings.Repeat("a", 300)})
+	if alert := screenAlert(screen); !strings.Contains(alert, "limit is 280") {
+		t.Errorf("saving a tweet that's too long showed %q", alert)
+	}
+	screen = configure(t, c, "saveTweet", map[string]string{"name": "bad", "message": "{{.Missing"})
+	if alert := screenAlert(screen); alert == "" {
+		t.Errorf("saving an invalid template worked")
+	}
+
+	// renaming keeps its place and ID
+	configure(t, c, "saveTweet", map[string]string{"id": one.ID, "name": "first", "message": "Hello one"})
+	if first, ok := a.StoredTweet("first"); !ok || first.ID != one.ID {
+		t.Errorf("renamed tweet is %+v", first)
+	}
+	if names := a.TweetNames(); !reflect.DeepEqual(names, []string{"first", "two"}) {
+		t.Errorf("stored tweets after renaming are %q", names)
+	}
+
+	configure(t, c, "moveTweetDown", map[string]string{"id": one.ID})
+	if names := a.TweetNames(); !reflect.DeepEqual(names, []string{"two", "first"}) {
+		t.Errorf("stored tweets after moving down are %q", names)
+	}
+	configure(t, c, "moveTweetDown", map[string]string{"id": one.ID})
+	if names := a.TweetNames(); !reflect.DeepEqual(names, []string{"two", "first"}) {
+		t.Errorf("stored tweets after moving the last one down are %q", names)
+	}
+
+	configure(t, c, "deleteTweet", map[string]string{"tweetName": "two"})
+	if names := a.TweetNames(); !reflect.DeepEqual(names, []string{"first"}) {
+		t.Errorf("stored tweets after deleting are %q", names)
+	}
+}
+
+func TestConfigureSaveTweetKeepsCount(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server, TweetDetails{Name: "hello", Message: "Hello"})
+	c := &ConfigService{a}
+	a.SendStoredTweet("hello", nil)
+
+	hello, _ := a.StoredTweet("hello")
+	configure(t, c, "saveTweet", map[string]string{"id": hello.ID, "name": "hello", "message": "Hi"})
+	if saved, _ := a.StoredTweet("hello"); saved.Number != 1 || saved.Message != "Hi" {
+		t.Errorf("saved tweet is %+v, want the new message and the count kept", saved)
+	}
+	configure(t, c, "resetCount", map[string]string{"id": hello.ID})
+	if saved, _ := a.StoredTweet("hello"); saved.Number != 0 || saved.Variation != 1 {
+		t.Errorf("after resetting, count is %d and variation is %d", saved.Number, saved.Variation)
+	}
+}
+
+func TestConfigureSaveGestures(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	c := &ConfigService{a}
+
+	for _, values := range []map[string]string{
+		{GestureFlickUp: "explode"},
+		{GestureFlickUp: ActionScroll},
+		{GestureAirWheel: ActionSend},
+	} {
+		if alert := screenAlert(configure(t, c, "saveGestures", values)); alert == "" {
+			t.Errorf("saving gestures %v worked", values)
+		}
+	}
+	if len(a.Config().Gestures) != 0 {
+		t.Fatalf("gestures are %v after invalid saves, want none", a.Config().Gestures)
+	}
+
+	configure(t, c, "saveGestures", map[string]string{GestureFlickUp: ActionCancel, GestureAirWheel: ActionNone})
+	if a.GestureAction(GestureFlickUp) != ActionCancel || a.GestureAction(GestureAirWheel) != ActionNone {
+		t.Errorf("gestures are %v after saving", a.Config().Gestures)
+	}
+}
+
+func TestConfigureSaveAccount(t *testing.T) {
+	server := newTwitterServer(t)
+	a := newTestApp(t, server)
+	c := &ConfigService{a}
+
+	account := server.addUser("@you")
+	screen := configure(t, c, "saveAccount", account)
+	if alert := screenAlert(screen); alert != "" {
+		t.Fatalf("saving @you showed %q", alert)
+	}
+	if !a.IsInitialised("@you") || !reflect.DeepEqual(a.AccountNames(), []string{"@me", "@you"}) {
+		t.Fatalf("accounts are %q after saving @you", a.AccountNames())
+	}
+
+	// a new account can't replace one that's already there
+	account.Username = "me"
+	if alert := screenAlert(configure(t, c, "saveAccount", account)); !strings.Contains(alert, "already an account called @me") {
+		t.Errorf("saving another @me showed %q", alert)
+	}
+	if saved, _ := a.Account("@me"); saved.AccessToken != "token-me" {
+		t.Errorf("@me was replaced with %+v", saved)
+	}
+
+	// blank secrets keep the saved ones when editing
+	configure(t, c, "saveAccount", map[string]string{"username": "@you", "previous": "@you",
+		"consumerkey": "key", "accesstoken": "token-you"})
+	if !a.IsInitialised("@you") {
+		t.Errorf("@you stopped working after saving it without changing the secrets")
+	}
+
+	configure(t, c, "delete", map[string]string{"username": "@you"})
+	if names := a.AccountNames(); !reflect.DeepEqual(names, []string{"@me"}) || a.IsInitialised("@you") {
+		t.Errorf("accounts are %q after deleting @you", names)
+	}
+}
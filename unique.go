@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+// Uniqueness strategies stop Twitter rejecting a stored tweet because it's the same as a recent one
+// UniqueCounter (the default) adds the tweet's variation number to the end unless the template uses {{.Count}},
+// UniqueTimestamp adds the time, UniqueInvisible adds zero-width characters that encode the variation number
+// and UniqueAlternatives rotates through the message and its alternative messages
+const (
+	UniqueNone         = "none"
+	UniqueCounter      = "counter"
+	UniqueTimestamp    = "timestamp"
+	UniqueInvisible    = "invisible"
+	UniqueAlternatives = "alternatives"
+)
+
+// uniqueNames is the order the strategies are shown in Labs, with their descriptions
+var uniqueNames = []string{UniqueCounter, UniqueTimestamp, UniqueInvisible, UniqueAlternatives, UniqueNone}
+
+var uniqueTitles = map[string]string{
+	UniqueCounter:      "Add a number to the end",
+	UniqueTimestamp:    "Add the time to the end",
+	UniqueInvisible:    "Add invisible characters to the end",
+	UniqueAlternatives: "Take turns with the alternative messages",
+	UniqueNone:         "Nothing (it may be rejected as a duplicate)",
+}
+
+// maxVariationRetries is how many more variations of a stored tweet are tried when Twitter says it's a duplicate
+const maxVariationRetries = 3
+
+// alternativeSeparator separates the alternative messages when they're edited in Labs
+const alternativeSeparator = "||"
+
+// zero-width characters for UniqueInvisible, which don't show in the tweet
+const (
+	zeroWidthSpace     = "\u200b"
+	zeroWidthNonJoiner = "\u200c"
+)
+
+// uniqueness returns the tweet's strategy for avoiding duplicates (blank or unknown ones are the default)
+func (t TweetDetails) uniqueness() string {
+	if _, ok := uniqueTitles[t.Unique]; ok {
+		return t.Unique
+	}
+	return UniqueCounter
+}
+
+// messageTemplate returns the message template for the tweet's current variation
+func (t TweetDetails) messageTemplate() string {
+	if t.uniqueness() != UniqueAlternatives || len(t.Alternatives) == 0 {
+		return t.Message
+	}
+	messages := append([]string{t.Message}, t.Alternatives...)
+	return messages[abs(t.Variation)%len(messages)]
+}
+
+// makeUnique changes message so it's different for each of tweet's variations (or times for UniqueTimestamp)
+// usesCount is whether the template already uses the count, which makes the counter unnecessary until retrying
+func makeUnique(message string, tweet TweetDetails, usesCount, retrying bool) string {
+	switch tweet.uniqueness() {
+	case UniqueCounter:
+		if retrying || !usesCount {
+			return fmt.Sprintf("%s %d", message, tweet.Variation)
+		}
+	case UniqueTimestamp:
+		// with milliseconds so the variations tried straight after a duplicate are different too
+		return message + " " + time.Now().Format("15:04:05.000")
+	case UniqueInvisible:
+		return message + invisibleSuffix(tweet.Variation)
+	}
+	return message
+}
+
+// invisibleSuffix encodes variation in binary with zero-width characters (after a zero-width space, so 0 has one too)
+func invisibleSuffix(variation int) string {
+	suffix := zeroWidthSpace
+	for v := abs(variation); v > 0; v /= 2 {
+		if v%2 == 1 {
+			suffix += zeroWidthNonJoiner
+		} else {
+			suffix += zeroWidthSpace
+		}
+	}
+	return suffix
+}
+
+// parseAlternatives splits the alternative messages from Labs, ignoring blank ones
+func parseAlternatives(text string) []string {
+	var alternatives []string
+	for _, alternative := range strings.Split(text, alternativeSeparator) {
+		if alternative = strings.TrimSpace(alternative); alternative != "" {
+			alternatives = append(alternatives, alternative)
+		}
+	}
+	return alternatives
+}
+
+// IsDuplicate returns whether err is Twitter rejecting a status as a duplicate of a recent one
+func IsDuplicate(err error) bool {
+	apiErr, ok := err.(*anaconda.ApiError)
+	if !ok {
+		return false
+	}
+	for _, e := range apiErr.Decoded.Errors {
+		if e.Code == anaconda.TwitterErrorStatusIsADuplicate {
+			return true
+		}
+	}
+	return false
+}